@@ -1,16 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
 
 	"wiget/internal/background"
 	"wiget/internal/downloader"
 	"wiget/internal/flags"
 	"wiget/internal/mirror"
+	"wiget/pkg/wiget"
 )
 
+// passwordEnv hands a password to the background process without putting it
+// on its command line, where other users could see it
+const passwordEnv = "WIGET_PASSWORD"
+
+// exitInterrupted is the status of a run stopped by Ctrl-C or SIGTERM, the
+// code shells use for a process killed by SIGINT
+const exitInterrupted = 130
+
 func main() {
 	// Check if arguments are provided
 	if len(os.Args) < 2 {
@@ -19,38 +39,295 @@ func main() {
 	}
 
 	inputs := flags.ParseArgs()
-
-	// Mirror website handling
-	if inputs.Mirroring {
-		// url, flagInput, convertLinks, pathRejects := mirror.GetMirrorUrl(inputs.args)
-		mirror.DownloadPage(inputs.URL, inputs.RejectFlag, inputs.ConvertLinksFlag, inputs.ExcludeFlag)
-		return
+	httpConfig, err := newHTTPConfig(inputs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(wiget.ExitCode(err))
 	}
-
-	// If no file name is provided, derive it from the URL
-	if inputs.File == "" && inputs.URL != "" {
-		urlParts := strings.Split(inputs.URL, "/")
-		inputs.File = urlParts[len(urlParts)-1]
+	report, closeReport, err := openReport(inputs.Report)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(wiget.ExitCode(err))
 	}
+	opts := wiget.Options{
+		RateLimit:     inputs.RateLimit,
+		Directory:     inputs.Path,
+		Continue:      inputs.Continue,
+		Split:         inputs.Split,
+		Checksum:      inputs.Checksum,
+		Quarantine:    inputs.Quarantine,
+		RemovePartial: inputs.RemovePartial,
+		Backups:       inputs.Backups,
+		Timestamping:  inputs.Timestamping,
+		Tries:         inputs.Tries,
+		WaitRetry:     time.Duration(inputs.WaitRetry) * time.Second,
+		Jobs:          inputs.Jobs,
+		MaxPerHost:    inputs.MaxPerHost,
+		ForceHTML:     inputs.ForceHTML,
+		BaseURL:       inputs.BaseURL,
+		HTTP:          wiget.NewHTTPClient(httpConfig),
+		Log:           os.Stdout,
+		Report:        report,
+	}
+	switch {
+	case inputs.NoClobber:
+		opts.Clobber = wiget.NoClobber
+	case inputs.Numbered:
+		opts.Clobber = wiget.Numbered
+	case inputs.Mirroring && inputs.Backups == 0 && !inputs.Timestamping:
+		// Re-running a mirror only fetches what is missing unless asked otherwise
+		opts.Clobber = wiget.NoClobber
+	}
+	client := wiget.NewClient(opts)
 
 	// Handle the work-in-background flag
 	if inputs.WorkInBackground {
-		background.DownloadInBackground(inputs.File, inputs.URL, inputs.RateLimit)
+		// The password, once asked for, is handed over out of sight
+		if inputs.AskPassword {
+			os.Setenv(passwordEnv, httpConfig.Password)
+		}
+		background.DownloadInBackground(inputs.File, inputs.URL, inputs.RateLimit, passThroughArgs(inputs.URL)...)
 		return
 	}
 
+	// Ctrl-C and SIGTERM stop the transfers in flight; partial files are kept
+	// for -c unless --remove-partial is set
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Every download is in the report, so only the failures around them are
+	// added here
+	report.Fail(run(ctx, inputs, client))
+
+	// The cookies are saved even after a failed or interrupted run, so a
+	// login is not lost
+	if inputs.SaveCookies != "" {
+		if err := httpConfig.Cookies.Save(inputs.SaveCookies, inputs.KeepSessionCookies); err != nil {
+			fmt.Println("Error:", err)
+			report.Fail(err)
+		}
+	}
+	if inputs.Sourcefile != "" || inputs.Mirroring || inputs.Recursive {
+		report.PrintSummary(os.Stdout)
+	}
+	if err := closeReport(); err != nil {
+		fmt.Println("Error writing report:", err)
+		report.Fail(err)
+	}
+	exitIfInterrupted(ctx, stop)
+	os.Exit(report.ExitCode())
+}
+
+// run carries out the mirror, list or single download inputs ask for and
+// returns the error that made it fail, if any
+func run(ctx context.Context, inputs flags.Inputs, client *wiget.Client) error {
+	// Mirror website handling
+	if inputs.Mirroring {
+		mirror.DownloadPage(ctx, inputs.URL, inputs.RejectFlag, inputs.ConvertLinksFlag, inputs.ExcludeFlag, client.Options())
+		return nil
+	}
+
 	// Handle multiple file downloads from sourcefile
 	if inputs.Sourcefile != "" {
-		downloader.DownloadMultipleFiles(inputs.Sourcefile, inputs.File, inputs.RateLimit, inputs.Path)
-		return
+		return downloader.DownloadMultipleFiles(ctx, inputs.Sourcefile, inputs.File, client.Options())
 	}
 
 	// Ensure URL is provided
 	if inputs.URL == "" {
 		fmt.Println("Error: URL not provided.")
-		return
+		return nil
+	}
+
+	// Handle recursive FTP retrieval
+	if inputs.Recursive {
+		return client.DownloadTree(ctx, inputs.URL)
 	}
 
 	// Start downloading the file
-	downloader.OneDownload(inputs.File, inputs.URL, inputs.RateLimit, inputs.Path)
+	toDisplay, err := background.LoadShowProgressState()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if toDisplay {
+		opts := client.Options()
+		opts.Progress = wiget.ProgressBar(os.Stdout)
+		client = wiget.NewClient(opts)
+	}
+	_, err = client.Download(ctx, wiget.Request{URL: inputs.URL, Output: inputs.File})
+	return err
+}
+
+// openReport starts the report of the run, written to path as JSON lines
+// unless path is empty. The returned function writes the totals and closes
+// the file.
+func openReport(path string) (*wiget.Report, func() error, error) {
+	if path == "" {
+		report := wiget.NewReport(nil)
+		return report, report.Close, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := wiget.NewReport(file)
+	return report, func() error {
+		err := report.Close()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// newHTTPConfig collects the request settings given on the command line.
+// --post-data and --post-file send a form POST, as wget does.
+func newHTTPConfig(inputs flags.Inputs) (wiget.HTTPConfig, error) {
+	config := wiget.HTTPConfig{
+		Header:         http.Header{},
+		UserAgent:      inputs.UserAgent,
+		Method:         inputs.Method,
+		ConnectTimeout: inputs.ConnectTimeout,
+		ReadTimeout:    inputs.ReadTimeout,
+		User:           inputs.User,
+		Password:       inputs.Password,
+		BearerToken:    inputs.BearerToken,
+		Netrc:          netrcPath(),
+		Cookies:        wiget.NewCookieJar(),
+		Proxy:          inputs.Proxy,
+		NoProxy:        inputs.NoProxy,
+
+		MaxRedirects:        inputs.MaxRedirect,
+		NoFollowRedirects:   inputs.NoFollowRedirects,
+		AllowHTTPSDowngrade: inputs.AllowHTTPSDowngrade,
+
+		Compression: inputs.Compression,
+		KeepEncoded: inputs.KeepEncoded,
+
+		FTPActive:    inputs.FTPActive,
+		FTPSImplicit: inputs.FTPSImplicit,
+	}
+	tlsConfig, err := downloader.NewTLSConfig(downloader.TLSOptions{
+		CACertificate:      inputs.CACertificate,
+		CADirectory:        inputs.CADirectory,
+		Certificate:        inputs.Certificate,
+		PrivateKey:         inputs.PrivateKey,
+		NoCheckCertificate: inputs.NoCheckCertificate,
+		MinVersion:         inputs.MinTLSVersion,
+		PinnedPublicKeys:   inputs.PinnedPublicKeys,
+	})
+	if err != nil {
+		return config, err
+	}
+	config.TLS = tlsConfig
+	if inputs.Proxy != "" {
+		if _, err := downloader.ParseProxyURL(inputs.Proxy); err != nil {
+			return config, err
+		}
+	}
+	if inputs.LoadCookies != "" {
+		err := config.Cookies.Load(inputs.LoadCookies)
+		if errors.Is(err, fs.ErrNotExist) {
+			// A first run with the same file for --load-cookies and --save-cookies
+			fmt.Printf("Warning: cookie file %s does not exist yet\n", inputs.LoadCookies)
+		} else if err != nil {
+			return config, err
+		}
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv(passwordEnv)
+	}
+	if inputs.AskPassword && config.Password == "" {
+		password, err := askPassword(inputs.User)
+		if err != nil {
+			return config, err
+		}
+		config.Password = password
+	}
+	for _, header := range inputs.Headers {
+		name, value, _ := strings.Cut(header, ":")
+		config.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	switch {
+	case inputs.PostData != "":
+		config.Body = []byte(inputs.PostData)
+	case inputs.PostFile != "":
+		body, err := os.ReadFile(inputs.PostFile)
+		if err != nil {
+			return config, fmt.Errorf("reading --post-file: %w", err)
+		}
+		config.Body = body
+	case inputs.BodyData != "":
+		config.Body = []byte(inputs.BodyData)
+	}
+	if inputs.PostData != "" || inputs.PostFile != "" {
+		config.Method = http.MethodPost
+		if config.Header.Get("Content-Type") == "" {
+			config.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	return config, nil
+}
+
+// netrcPath returns the .netrc file credentials are looked up in: $NETRC or
+// ~/.netrc
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// askPassword prompts for the password of user on the terminal without
+// echoing it, or reads a line from standard input when it is not a terminal
+func askPassword(user string) (string, error) {
+	if user != "" {
+		fmt.Fprintf(os.Stderr, "Password for user %s: ", user)
+	} else {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		return string(password), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// exitIfInterrupted ends the program with exitInterrupted when ctx was
+// cancelled by a signal
+func exitIfInterrupted(ctx context.Context, stop context.CancelFunc) {
+	if ctx.Err() == nil {
+		return
+	}
+	stop()
+	fmt.Println("Interrupted.")
+	os.Exit(exitInterrupted)
+}
+
+// passThroughArgs returns the flags the background process needs on top of
+// the ones DownloadInBackground sets itself
+func passThroughArgs(url string) []string {
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg == url || strings.HasPrefix(arg, "-B") || strings.HasPrefix(arg, "-O=") ||
+			strings.HasPrefix(arg, "-P=") || strings.HasPrefix(arg, "--rate-limit=") || arg == "--ask-password" {
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args
 }
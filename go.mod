@@ -1,5 +1,13 @@
 module wiget
 
-go 1.18
+go 1.22
 
-require golang.org/x/net v0.28.0
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/net v0.28.0
+	golang.org/x/term v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.23.0 // indirect
@@ -6,23 +6,20 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
 )
 
 const tempConfigFile = "progress_config.txt"
 
-func DownloadInBackground(file, urlStr, rateLimit string) {
-	// Parse the URL to derive the output name
-	parsedURL, err := url.Parse(urlStr)
-	if err != nil {
+// DownloadInBackground restarts the program as a detached process that logs to
+// "wget-log". extraArgs are passed on to the child unchanged.
+func DownloadInBackground(file, urlStr, rateLimit string, extraArgs ...string) {
+	// Check the URL before detaching; the child names the file from the
+	// response unless file is set
+	if _, err := url.Parse(urlStr); err != nil {
 		fmt.Println("Invalid URL:", err)
 		return
 	}
-	outputName := filepath.Base(parsedURL.Path) // Get the file name from the URL
-	if file != "" {
-		outputName = file
-	}
 
 	path := "." // Default path to save the file
 	// Create the wget-log file to log output
@@ -38,7 +35,12 @@ func DownloadInBackground(file, urlStr, rateLimit string) {
 		fmt.Println("Error creating output directory:", err)
 		return
 	}
-	cmd := exec.Command(os.Args[0], "-O="+outputName, "-P="+path, "--rate-limit="+rateLimit, urlStr)
+	args := []string{"-P=" + path, "--rate-limit=" + rateLimit}
+	if file != "" {
+		args = append(args, "-O="+file)
+	}
+	args = append(args, extraArgs...)
+	cmd := exec.Command(os.Args[0], append(args, urlStr)...)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
@@ -1,110 +1,247 @@
 package downloader
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
-
-	"wiget/internal/rateLimiter"
 )
 
-func DownloadMultipleFiles(filePath, outputFile, limit, directory string) {
-	file, err := os.Open(filePath)
+// DownloadMultipleFiles downloads every URL listed in source, opts.Jobs at
+// a time and at most opts.MaxPerHost from the same host. source is a local
+// file, "-" for the standard input, or the URL of a list to fetch first. In
+// its simplest form the list holds a URL or a local path per line,
+// optionally followed by the checksum of that file:
+//
+//	https://example.com/file.iso sha256:9f86d081884c7d65...
+//	./exports/report.csv
+//
+// See scanList for the options a list can give each file. With
+// opts.ForceHTML, or when a fetched list is served as text/html, the list is
+// an HTML page and its links are downloaded instead. A fetched list may not
+// name local files or save files outside opts.Directory.
+//
+// outputFile (-O) names the one file that the list does not name itself; a
+// list with several such files is refused. Otherwise the list is read as the
+// downloads go, so a huge one is never held in memory. It returns an error
+// when any of the downloads failed. Cancelling ctx stops
+// the downloads that are still running.
+func DownloadMultipleFiles(ctx context.Context, source, outputFile string, opts Options) error {
+	list, err := openList(ctx, source, opts)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return
+		fmt.Println("Error reading file:", err)
+		return err
 	}
-	defer file.Close()
+	defer list.Close()
+	return downloadList(ctx, list, outputFile, opts)
+}
 
-	var wg sync.WaitGroup
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		url := strings.TrimSpace(scanner.Text())
+// downloadList downloads the requests of list with a pool of workers
+func downloadList(ctx context.Context, list *inputList, outputFile string, opts Options) error {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = DefaultJobs
+	}
+	queue := newScheduler(ctx, queueWindow, opts.MaxPerHost)
+	defer queue.stop()
 
-		if url == "" {
-			continue // Skip empty lines
-		}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	claims := newOutputClaims()
+	for i := 0; i < jobs; i++ {
 		wg.Add(1)
-		go func(url string) {
+		go func() {
 			defer wg.Done()
-			AsyncDownload(outputFile, url, limit, directory)
-		}(url)
+			for {
+				req, ok := queue.pop()
+				if !ok {
+					return
+				}
+				// Entries saved under the same name take turns, so that the
+				// clobber settings decide between them as in a sequential run
+				output := outputPath(req, opts)
+				claims.claim(output)
+				err := ctx.Err()
+				if err == nil {
+					err = asyncDownload(ctx, req, opts)
+				}
+				claims.release(output)
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				queue.done(req)
+			}
+		}()
 	}
-	wg.Wait()
-}
 
-func AsyncDownload(outputFileName, url, limit, directory string) {
-	path := ExpandPath(directory)
+	scan := list.scan
+	if outputFile != "" {
+		requests, err := claimOutput(list, outputFile)
+		if err != nil {
+			queue.close()
+			wg.Wait()
+			fmt.Println("Error:", err)
+			return err
+		}
+		scan = func(yield func(Request) bool) error {
+			for _, req := range requests {
+				if !yield(req) {
+					break
+				}
+			}
+			return nil
+		}
+	}
 
-	resp, err := HttpRequest(url)
+	total := 0
+	err := scan(func(req Request) bool {
+		total++
+		return queue.push(req)
+	})
+	queue.close()
+	wg.Wait()
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Println("Error reading file:", err)
+		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Error: status %s url: [%s]\n", resp.Status, url)
-		return
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, total)
 	}
+	return nil
+}
 
-	if outputFileName == "" {
-		urlParts := strings.Split(url, "/")
-		fileName := urlParts[len(urlParts)-1]
-		outputFileName = filepath.Join(path, fileName)
-	} else {
-		outputFileName = filepath.Join(path, outputFileName)
+// outputPath returns the file req is expected to be saved as with opts
+func outputPath(req Request, opts Options) string {
+	name := req.Output
+	if name == "" {
+		name = FileNameFromURL(SourceURL(req.URL))
 	}
+	return filepath.Join(expandPath(io.Discard, req.options(opts).Directory), name)
+}
 
-	if path != "" {
-		err = os.MkdirAll(path, 0o755)
-		if err != nil {
-			fmt.Println("Error creating directory:", err)
-			return
-		}
+// outputClaims hands each output file of a list to one download at a time
+type outputClaims struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	inUse map[string]bool
+}
+
+func newOutputClaims() *outputClaims {
+	c := &outputClaims{inUse: map[string]bool{}}
+	c.freed = sync.NewCond(&c.mu)
+	return c
+}
+
+// claim waits until no other download saves to path and takes it
+func (c *outputClaims) claim(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.inUse[path] {
+		c.freed.Wait()
 	}
+	c.inUse[path] = true
+}
+
+// release hands path to the next download waiting for it
+func (c *outputClaims) release(path string) {
+	c.mu.Lock()
+	delete(c.inUse, path)
+	c.mu.Unlock()
+	c.freed.Broadcast()
+}
 
-	var out *os.File
-	out, err = os.Create(outputFileName)
+// claimOutput reads the whole of list and saves its one entry without an
+// out= option as outputFile (-O). Several downloads cannot share a file, so a
+// list with more such entries is refused before anything is downloaded.
+func claimOutput(list *inputList, outputFile string) ([]Request, error) {
+	var requests []Request
+	unnamed := 0
+	err := list.scan(func(req Request) bool {
+		if req.Output == "" {
+			req.Output = outputFile
+			unnamed++
+		}
+		requests = append(requests, req)
+		return true
+	})
 	if err != nil {
-		fmt.Printf("Error creating file: %s\n", err)
-		return
+		return nil, err
+	}
+	if unnamed > 1 {
+		return nil, fmt.Errorf("-O %s cannot hold %d downloads, give the entries of the list an out= option instead", outputFile, unnamed)
+	}
+	return requests, nil
+}
+
+// AsyncDownload downloads url without drawing a progress bar, so that several
+// downloads can run side by side. The returned error has already been printed.
+func AsyncDownload(ctx context.Context, outputFileName, url string, opts Options) error {
+	return asyncDownload(ctx, Request{URL: url, Output: outputFileName}, opts)
+}
+
+// asyncDownload is AsyncDownload for a request with its own settings. The
+// messages of the download, such as where an existing file was moved to, are
+// printed with the name of the file in front of them.
+func asyncDownload(ctx context.Context, req Request, opts Options) error {
+	url := req.URL
+	name := req.Output
+	if name == "" {
+		name = FileNameFromURL(SourceURL(url))
 	}
-	defer out.Close()
+	opts.Log, opts.Progress = &prefixWriter{out: os.Stdout, prefix: "[" + name + "] "}, nil
 
-	var reader io.Reader = resp.Body
-	if limit != "" {
-		reader = rateLimiter.NewRateLimitedReader(resp.Body, limit)
+	fmt.Printf("Downloading.... [%s]\n", RedactURL(url))
+	result, err := Download(ctx, req, opts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return err
+	}
+	if result.UpToDate {
+		fmt.Printf("Already fully retrieved [%s]\n", RedactURL(url))
+		return nil
+	}
+	if result.Skipped {
+		fmt.Printf("Skipped [%s], %s already exists\n", RedactURL(url), result.Path)
+		return nil
 	}
 
-	buffer := make([]byte, 32*1024) // 32 KB buffer size
-	fmt.Printf("Downloading.... [%s]\n", url)
-	var downloaded int64
+	fmt.Printf("\033[32mDownloaded\033[0m [%s]\n", RedactURL(url))
+	return nil
+}
+
+// prefixWriter writes each line it is given to out with prefix in front of
+// it, so that the lines of downloads running side by side can be told apart.
+// Blank lines are dropped. It is safe for concurrent use, as the segments of
+// a --split download write to it side by side.
+type prefixWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
 	for {
-		n, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			fmt.Println("Error reading response body:", err)
-			return
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
 		}
-
-		if n > 0 {
-			if _, err := out.Write(buffer[:n]); err != nil {
-				fmt.Println("Error writing to file:", err)
-				return
-			}
-			downloaded += int64(n)
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
 		}
-
-		if err == io.EOF {
-			break
+		if _, err := fmt.Fprintf(w.out, "%s%s\n", w.prefix, line); err != nil {
+			return len(p), err
 		}
 	}
-
-	// endTime := time.Now()
-	fmt.Printf("\033[32mDownloaded\033[0m [%s]\n", url)
-	// fmt.Printf("Finished at %s\n", endTime.Format("2006-01-02 15:04:05"))
 }
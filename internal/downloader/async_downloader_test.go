@@ -2,8 +2,13 @@ package downloader
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -132,7 +137,7 @@ func TestDownloadMultipleFiles(t *testing.T) {
 			}
 
 			// Call the function
-			DownloadMultipleFiles(tt.args.filePath, tt.args.outputFile, tt.args.limit, tt.args.directory)
+			DownloadMultipleFiles(context.Background(), tt.args.filePath, tt.args.outputFile, Options{RateLimit: tt.args.limit, Directory: tt.args.directory})
 
 			if !tt.expectFail && err == nil {
 				// Check if the output file exists
@@ -144,3 +149,30 @@ func TestDownloadMultipleFiles(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefixWriterConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{out: &out, prefix: "[a.txt] "}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(w, "segment %d: retrying\n", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 8*50 {
+		t.Fatalf("got %d lines, want %d", len(lines), 8*50)
+	}
+	for _, line := range lines {
+		var i int
+		if _, err := fmt.Sscanf(line, "[a.txt] segment %d: retrying", &i); err != nil {
+			t.Fatalf("torn line %q", line)
+		}
+	}
+}
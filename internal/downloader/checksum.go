@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ChecksumAuto asks for the digest to be read from a "<url>.sha256" file
+// published next to the download.
+const ChecksumAuto = "auto"
+
+// quarantineSuffix is appended to files that failed verification when they
+// are kept instead of deleted.
+const quarantineSuffix = ".quarantine"
+
+// Checksum is an expected digest, written as "algorithm:hex" on the command line
+type Checksum struct {
+	Algorithm string
+	Digest    string
+}
+
+// digestSizes maps the supported algorithms to the length of their hex digest
+var digestSizes = map[string]int{
+	"md5":    md5.Size * 2,
+	"sha1":   sha1.Size * 2,
+	"sha256": sha256.Size * 2,
+	"sha512": sha512.Size * 2,
+}
+
+// ChecksumError is returned when a downloaded file does not match its digest
+type ChecksumError struct {
+	File      string
+	Algorithm string
+	Want      string
+	Got       string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch for %s: expected %s, got %s", e.Algorithm, e.File, e.Want, e.Got)
+}
+
+// ParseChecksum reads a digest such as "sha256:9f86d081...". "=" is accepted
+// in place of ":".
+func ParseChecksum(value string) (Checksum, error) {
+	sep := strings.IndexAny(value, ":=")
+	if sep < 0 {
+		return Checksum{}, fmt.Errorf("invalid checksum %q, expected algorithm:digest", value)
+	}
+	sum := Checksum{
+		Algorithm: strings.ToLower(value[:sep]),
+		Digest:    strings.ToLower(value[sep+1:]),
+	}
+
+	size, ok := digestSizes[sum.Algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm %q (use md5, sha1, sha256 or sha512)", sum.Algorithm)
+	}
+	if _, err := hex.DecodeString(sum.Digest); err != nil || len(sum.Digest) != size {
+		return Checksum{}, fmt.Errorf("invalid %s digest %q", sum.Algorithm, sum.Digest)
+	}
+	return sum, nil
+}
+
+// IsZero reports whether no checksum was given
+func (c Checksum) IsZero() bool {
+	return c.Algorithm == ""
+}
+
+func (c Checksum) newHash() hash.Hash {
+	switch c.Algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// resolveChecksum turns the --checksum value for url into the digest to check.
+// An empty value disables verification.
+func resolveChecksum(ctx context.Context, opts Options, value, url string) (Checksum, error) {
+	switch value {
+	case "":
+		return Checksum{}, nil
+	case ChecksumAuto:
+		return fetchSidecarChecksum(ctx, opts, url)
+	}
+	return ParseChecksum(value)
+}
+
+// fetchSidecarChecksum reads the sha256 digest published at "<url>.sha256".
+// Both a bare digest and the "digest  filename" format of sha256sum are
+// understood. A missing sidecar disables verification with a warning.
+func fetchSidecarChecksum(ctx context.Context, opts Options, url string) (Checksum, error) {
+	sidecar := url + ".sha256"
+	resp, err := opts.client().do(ctx, "GET", sidecar, nil, nil)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("error fetching checksum: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(opts.log(), "Warning: no checksum published at [%s], skipping verification\n", RedactURL(sidecar))
+		return Checksum{}, nil
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return Checksum{}, fmt.Errorf("error reading checksum: %w", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Checksum{}, fmt.Errorf("empty checksum file [%s]", sidecar)
+	}
+	return ParseChecksum("sha256:" + fields[0])
+}
+
+// verifier hashes a download while it is written to disk
+type verifier struct {
+	sum  Checksum
+	hash hash.Hash
+}
+
+// newVerifier starts hashing a download of file whose first offset bytes are
+// already on disk. Without an expected sum the file is still hashed with
+// sha256 so that the digest can be reported.
+func newVerifier(sum Checksum, file string, offset int64) (*verifier, error) {
+	if sum.IsZero() {
+		sum = Checksum{Algorithm: "sha256"}
+	}
+	v := &verifier{sum: sum, hash: sum.newHash()}
+	if offset > 0 {
+		in, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		if _, err := io.CopyN(v.hash, in, offset); err != nil {
+			return nil, fmt.Errorf("error reading partial file: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// writer returns out, also feeding the hash
+func (v *verifier) writer(out io.Writer) io.Writer {
+	return io.MultiWriter(out, v.hash)
+}
+
+// check compares the hash with the expected digest, if there is one, and
+// returns it as "algorithm:digest".
+func (v *verifier) check(file string) (string, error) {
+	got := hex.EncodeToString(v.hash.Sum(nil))
+	if v.sum.Digest != "" && got != v.sum.Digest {
+		return "", &ChecksumError{File: file, Algorithm: v.sum.Algorithm, Want: v.sum.Digest, Got: got}
+	}
+	return v.sum.Algorithm + ":" + got, nil
+}
+
+// VerifyFile hashes file from disk and compares it against sum
+func VerifyFile(file string, sum Checksum) error {
+	v, err := hashFile(file, sum)
+	if err != nil {
+		return err
+	}
+	_, err = v.check(file)
+	return err
+}
+
+// hashFile feeds the contents of file to a new verifier for sum
+func hashFile(file string, sum Checksum) (*verifier, error) {
+	v, err := newVerifier(sum, file, 0)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	if _, err := io.Copy(v.hash, in); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file, err)
+	}
+	return v, nil
+}
+
+// verifyDownload checks a finished download of file, still in its .part file,
+// against sum and returns its digest. v holds the hash of the streamed body;
+// when it is nil the file is hashed from disk, but only if a digest was asked
+// for. A file that does not match is rejected.
+func verifyDownload(log io.Writer, file string, v *verifier, sum Checksum, quarantine bool) (string, error) {
+	var digest string
+	var err error
+	if v == nil && !sum.IsZero() {
+		v, err = hashFile(PartPath(file), sum)
+	}
+	if v != nil && err == nil {
+		digest, err = v.check(file)
+	}
+	if err != nil {
+		var sumErr *ChecksumError
+		if errors.As(err, &sumErr) {
+			rejectFile(log, file, quarantine)
+		}
+		return "", err
+	}
+	if !sum.IsZero() {
+		fmt.Fprintf(log, "%s checksum verified\n", sum.Algorithm)
+	}
+	return digest, nil
+}
+
+// rejectFile removes a download of file that failed verification, or moves it
+// aside when quarantine is set so it can be inspected.
+func rejectFile(log io.Writer, file string, quarantine bool) {
+	if quarantine {
+		if err := os.Rename(PartPath(file), file+quarantineSuffix); err == nil {
+			removeResumeMeta(file)
+			fmt.Fprintf(log, "moved %s to %s\n", file, file+quarantineSuffix)
+			return
+		}
+	}
+	RemovePartial(file)
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Checksum
+		wantErr bool
+	}{
+		{
+			name:  "sha256 with colon",
+			value: "sha256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08",
+			want:  Checksum{Algorithm: "sha256", Digest: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		},
+		{
+			name:  "md5 with equals sign",
+			value: "md5=098f6bcd4621d373cade4e832627b4f6",
+			want:  Checksum{Algorithm: "md5", Digest: "098f6bcd4621d373cade4e832627b4f6"},
+		},
+		{name: "Missing algorithm", value: "098f6bcd4621d373cade4e832627b4f6", wantErr: true},
+		{name: "Unknown algorithm", value: "crc32:d87f7e0c", wantErr: true},
+		{name: "Wrong length", value: "sha1:abcd", wantErr: true},
+		{name: "Not hex", value: "md5:zz8f6bcd4621d373cade4e832627b4f6", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecksum(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseChecksum() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOneDownloadChecksum(t *testing.T) {
+	content := []byte(strings.Repeat("verify me\n", 300))
+	digest := sha256.Sum256(content)
+	good := hex.EncodeToString(digest[:])
+	bad := strings.Repeat("0", 64)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt":
+			w.Write(content)
+		case "/file.txt.sha256":
+			w.Write([]byte(good + "  file.txt\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		file           string
+		checksum       string
+		quarantine     bool
+		wantErr        bool
+		wantFile       bool
+		wantQuarantine bool
+	}{
+		{name: "Matching digest", file: "file.txt", checksum: "sha256:" + good, wantFile: true},
+		{name: "Digest from sidecar file", file: "file.txt", checksum: ChecksumAuto, wantFile: true},
+		{name: "Mismatch deletes the file", file: "file.txt", checksum: "sha256:" + bad, wantErr: true},
+		{name: "Mismatch with quarantine", file: "file.txt", checksum: "sha256:" + bad, quarantine: true, wantErr: true, wantQuarantine: true},
+		{name: "Invalid checksum", file: "file.txt", checksum: "sha256:xyz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var err error
+			captureOutput(func() {
+				err = OneDownload(context.Background(), tt.file, server.URL+"/"+tt.file, Options{Directory: dir, Checksum: tt.checksum, Quarantine: tt.quarantine})
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OneDownload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			output := filepath.Join(dir, tt.file)
+			if _, err := os.Stat(output); (err == nil) != tt.wantFile {
+				t.Errorf("output exists = %v, want %v", err == nil, tt.wantFile)
+			}
+			if _, err := os.Stat(output + quarantineSuffix); (err == nil) != tt.wantQuarantine {
+				t.Errorf("quarantined copy exists = %v, want %v", err == nil, tt.wantQuarantine)
+			}
+		})
+	}
+}
+
+func TestDownloadMultipleFilesChecksum(t *testing.T) {
+	content := []byte("batch content")
+	digest := sha256.Sum256(content)
+	good := hex.EncodeToString(digest[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	list := filepath.Join(dir, "urls.txt")
+	urls := []string{
+		server.URL + "/good.txt sha256:" + good,
+		server.URL + "/bad.txt sha256:" + strings.Repeat("f", 64),
+		server.URL + "/plain.txt",
+	}
+	if err := createMockFile(list, urls); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	captureOutput(func() {
+		err = DownloadMultipleFiles(context.Background(), list, "", Options{Directory: dir})
+	})
+	if err == nil {
+		t.Errorf("DownloadMultipleFiles() succeeded, want an error for the bad checksum")
+	}
+	for name, want := range map[string]bool{"good.txt": true, "bad.txt": false, "plain.txt": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+}
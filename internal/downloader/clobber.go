@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+)
+
+// ClobberPolicy says what happens when a download would be saved over a file
+// that already exists
+type ClobberPolicy int
+
+const (
+	Overwrite ClobberPolicy = iota // replace the file, keeping Options.Backups old copies
+	NoClobber                      // keep the file and skip the download (--no-clobber)
+	Numbered                       // save the download as file.1, file.2, ... (--numbered)
+)
+
+// SkipExisting reports whether the download to file is skipped because the
+// file exists and opts.Clobber is NoClobber. The decision is logged.
+func SkipExisting(file string, opts Options) bool {
+	if opts.Clobber != NoClobber || !fileExists(file) {
+		return false
+	}
+	fmt.Fprintf(opts.log(), "File %s already there; not retrieving.\n", file)
+	return true
+}
+
+// PlaceOutput moves the completed .part file of file into place following
+// opts.Clobber and opts.Backups, and returns the path it was saved to.
+func PlaceOutput(file string, opts Options) (string, error) {
+	log := opts.log()
+	if fileExists(file) {
+		switch {
+		case opts.Clobber == Numbered:
+			numbered := nextNumberedName(file)
+			fmt.Fprintf(log, "%s exists, saving as %s\n", file, numbered)
+			if err := os.Rename(PartPath(file), numbered); err != nil {
+				return "", fmt.Errorf("error saving file: %w", err)
+			}
+			removeResumeMeta(file)
+			return numbered, nil
+		case opts.Backups > 0:
+			if err := rotateBackups(file, opts.Backups); err != nil {
+				return "", err
+			}
+			fmt.Fprintf(log, "backed up %s to %s.1\n", file, file)
+		default:
+			fmt.Fprintf(log, "overwriting %s\n", file)
+		}
+	}
+	return file, FinishOutput(file)
+}
+
+// nextNumberedName returns the first of file.1, file.2, ... that is free
+func nextNumberedName(file string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", file, i)
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
+
+// rotateBackups shifts file.1 ... file.(n-1) up by one, dropping file.n, and
+// moves file to file.1
+func rotateBackups(file string, n int) error {
+	for i := n - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", file, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", file, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating backups: %w", err)
+		}
+	}
+	if err := os.Rename(file, file+".1"); err != nil {
+		return fmt.Errorf("error backing up %s: %w", file, err)
+	}
+	return nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Lstat(file)
+	return err == nil
+}
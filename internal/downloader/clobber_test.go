@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadClobber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		opts     Options
+		existing map[string]string
+		wantPath string
+		want     map[string]string
+	}{
+		{
+			name:     "Overwrite",
+			existing: map[string]string{"file.txt": "old"},
+			wantPath: "file.txt",
+			want:     map[string]string{"file.txt": "new"},
+		},
+		{
+			name:     "No clobber keeps the file",
+			opts:     Options{Clobber: NoClobber},
+			existing: map[string]string{"file.txt": "old"},
+			wantPath: "file.txt",
+			want:     map[string]string{"file.txt": "old"},
+		},
+		{
+			name:     "No clobber downloads missing files",
+			opts:     Options{Clobber: NoClobber},
+			wantPath: "file.txt",
+			want:     map[string]string{"file.txt": "new"},
+		},
+		{
+			name:     "Numbered copy",
+			opts:     Options{Clobber: Numbered},
+			existing: map[string]string{"file.txt": "old", "file.txt.1": "older"},
+			wantPath: "file.txt.2",
+			want:     map[string]string{"file.txt": "old", "file.txt.1": "older", "file.txt.2": "new"},
+		},
+		{
+			name:     "Backups are rotated",
+			opts:     Options{Backups: 2},
+			existing: map[string]string{"file.txt": "v3", "file.txt.1": "v2", "file.txt.2": "v1"},
+			wantPath: "file.txt",
+			want:     map[string]string{"file.txt": "new", "file.txt.1": "v3", "file.txt.2": "v2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := tt.opts
+			opts.Directory = dir
+			res, err := Download(context.Background(), Request{URL: server.URL + "/file.txt"}, opts)
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if want := filepath.Join(dir, tt.wantPath); res.Path != want {
+				t.Errorf("Path = %q, want %q", res.Path, want)
+			}
+			if res.Skipped != (tt.opts.Clobber == NoClobber && len(tt.existing) > 0) {
+				t.Errorf("Skipped = %v", res.Skipped)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != len(tt.want) {
+				t.Errorf("directory holds %d files, want %d", len(entries), len(tt.want))
+			}
+			for name, want := range tt.want {
+				if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in a cookies.txt file, as curl and
+// browsers write them
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar keeps the cookies set by servers and sends them back following
+// the domain, path and secure rules of RFC 6265. Unlike net/http/cookiejar it
+// can be loaded from and saved to a Netscape cookies.txt file. It is safe for
+// concurrent use.
+type CookieJar struct {
+	mu      sync.Mutex
+	cookies map[string]jarCookie // keyed by domain, path and name
+}
+
+type jarCookie struct {
+	Name     string
+	Value    string
+	Domain   string // lower case, without a leading dot
+	Path     string
+	HostOnly bool      // only sent to Domain itself, not to its subdomains
+	Secure   bool      // only sent over https
+	HTTPOnly bool      // kept for the cookies.txt file
+	Expires  time.Time // zero for a session cookie
+}
+
+func (c jarCookie) key() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+func (c jarCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// NewCookieJar returns an empty CookieJar
+func NewCookieJar() *CookieJar {
+	return &CookieJar{cookies: map[string]jarCookie{}}
+}
+
+// SetCookies stores the cookies a response from u set. Cookies for another
+// domain, or for a public suffix such as "co.uk", are ignored.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := canonicalHost(u)
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cookie := range cookies {
+		entry := jarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HTTPOnly: cookie.HttpOnly,
+		}
+		domain, hostOnly, ok := cookieDomain(host, cookie.Domain)
+		if !ok {
+			continue
+		}
+		entry.Domain, entry.HostOnly = domain, hostOnly
+		if !strings.HasPrefix(entry.Path, "/") {
+			entry.Path = defaultCookiePath(u.Path)
+		}
+
+		switch {
+		case cookie.MaxAge < 0:
+			entry.Expires = now // deleted by the server
+		case cookie.MaxAge > 0:
+			entry.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			entry.Expires = cookie.Expires
+		}
+		if entry.expired(now) {
+			delete(j.cookies, entry.key())
+			continue
+		}
+		j.cookies[entry.key()] = entry
+	}
+}
+
+// Cookies returns the cookies to send in a request for u, longest path first
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	host := canonicalHost(u)
+	secure := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+
+	j.mu.Lock()
+	var matches []jarCookie
+	for key, entry := range j.cookies {
+		if entry.expired(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		if entry.Secure && !secure {
+			continue
+		}
+		if !domainMatch(host, entry) || !pathMatch(path, entry.Path) {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	j.mu.Unlock()
+
+	sort.Slice(matches, func(a, b int) bool {
+		if len(matches[a].Path) != len(matches[b].Path) {
+			return len(matches[a].Path) > len(matches[b].Path)
+		}
+		return matches[a].Name < matches[b].Name
+	})
+	cookies := make([]*http.Cookie, len(matches))
+	for i, entry := range matches {
+		cookies[i] = &http.Cookie{Name: entry.Name, Value: entry.Value}
+	}
+	return cookies
+}
+
+// Load adds the cookies of the Netscape cookies.txt file at path. Expired
+// cookies are skipped; session cookies, saved with an expiry of 0, are kept.
+func (j *CookieJar) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error loading cookies: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("error loading cookies: %s:%d: want 7 tab separated fields, got %d", path, n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("error loading cookies: %s:%d: invalid expiry %q", path, n, fields[4])
+		}
+		entry := jarCookie{
+			Domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HTTPOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+		}
+		if expires > 0 {
+			entry.Expires = time.Unix(expires, 0)
+		}
+		if entry.Domain == "" || entry.expired(now) {
+			continue
+		}
+		j.cookies[entry.key()] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error loading cookies: %w", err)
+	}
+	return nil
+}
+
+// Save writes the cookies of the jar to path in the Netscape cookies.txt
+// format. Session cookies are only written when keepSession is set, with an
+// expiry of 0.
+func (j *CookieJar) Save(path string, keepSession bool) error {
+	now := time.Now()
+	j.mu.Lock()
+	var entries []jarCookie
+	for _, entry := range j.cookies {
+		if entry.expired(now) || (entry.Expires.IsZero() && !keepSession) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	j.mu.Unlock()
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].key() < entries[b].key()
+	})
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n# Generated by wiget. Edit at your own risk.\n\n")
+	for _, entry := range entries {
+		domain, subdomains := entry.Domain, "FALSE"
+		if !entry.HostOnly {
+			domain, subdomains = "."+entry.Domain, "TRUE"
+		}
+		if entry.HTTPOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		secure := "FALSE"
+		if entry.Secure {
+			secure = "TRUE"
+		}
+		expires := int64(0)
+		if !entry.Expires.IsZero() {
+			expires = entry.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, subdomains, entry.Path, secure, expires, entry.Name, entry.Value)
+	}
+
+	// Write next to the target and rename, so an interrupted save never
+	// leaves a truncated file behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cookies-*")
+	if err != nil {
+		return fmt.Errorf("error saving cookies: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving cookies: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving cookies: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving cookies: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error saving cookies: %w", err)
+	}
+	return nil
+}
+
+// canonicalHost returns the host of u in lower case, without a port
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// cookieDomain works out the domain a cookie from host applies to. Without a
+// Domain attribute the cookie is host-only. A Domain attribute must be host
+// or one of its parents, and may not be a public suffix.
+func cookieDomain(host, attr string) (string, bool, bool) {
+	attr = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(attr, "."), "."))
+	if attr == "" || attr == host {
+		return host, attr == "", true
+	}
+	if net.ParseIP(host) != nil {
+		return "", false, false // IP addresses have no parent domains
+	}
+	if !strings.HasSuffix(host, "."+attr) {
+		return "", false, false
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(attr); suffix == attr {
+		return "", false, false
+	}
+	return attr, false, true
+}
+
+// domainMatch reports whether a cookie stored for entry.Domain is sent to host
+func domainMatch(host string, entry jarCookie) bool {
+	if host == entry.Domain {
+		return true
+	}
+	return !entry.HostOnly && strings.HasSuffix(host, "."+entry.Domain)
+}
+
+// pathMatch implements the path-match rule of RFC 6265 section 5.1.4
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath is the path of a cookie set without a Path attribute:
+// the directory of the request path
+func defaultCookiePath(requestPath string) string {
+	i := strings.LastIndex(requestPath, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return requestPath[:i]
+}
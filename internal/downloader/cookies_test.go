@@ -0,0 +1,135 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieJarScoping(t *testing.T) {
+	jar := NewCookieJar()
+	set := func(rawURL string, cookies ...*http.Cookie) {
+		u, _ := url.Parse(rawURL)
+		jar.SetCookies(u, cookies)
+	}
+	set("https://www.example.com/account/login",
+		&http.Cookie{Name: "host", Value: "1"},
+		&http.Cookie{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		&http.Cookie{Name: "secure", Value: "3", Path: "/", Secure: true},
+		&http.Cookie{Name: "docs", Value: "4", Path: "/docs"},
+		&http.Cookie{Name: "suffix", Value: "5", Domain: "com"},
+		&http.Cookie{Name: "other", Value: "6", Domain: "other.org"},
+		&http.Cookie{Name: "gone", Value: "7", MaxAge: -1},
+	)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://www.example.com/account/profile", want: "domain=2 host=1 secure=3"},
+		{url: "http://www.example.com/account/", want: "domain=2 host=1"},
+		{url: "https://api.example.com/", want: "domain=2"},
+		{url: "https://www.example.com/docs/intro", want: "docs=4 domain=2 secure=3"},
+		{url: "https://www.example.com/docsearch", want: "domain=2 secure=3"},
+		{url: "https://other.org/", want: ""},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var got []string
+		for _, cookie := range jar.Cookies(u) {
+			got = append(got, cookie.Name+"="+cookie.Value)
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("Cookies(%s) = %q, want %q", tt.url, strings.Join(got, " "), tt.want)
+		}
+	}
+}
+
+func TestCookieJarSaveAndLoad(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	jar := NewCookieJar()
+	u, _ := url.Parse("https://example.com/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "persistent", Value: "p", Domain: "example.com", Expires: expires, HttpOnly: true},
+		{Name: "session", Value: "s", Secure: true},
+	})
+
+	tests := []struct {
+		name        string
+		keepSession bool
+		want        string
+	}{
+		{name: "Session cookies are dropped", want: "persistent=p"},
+		{name: "Session cookies are kept", keepSession: true, want: "persistent=p session=s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cookies.txt")
+			if err := jar.Save(path, tt.keepSession); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if !strings.Contains(string(data), "#HttpOnly_.example.com\tTRUE\t/\tFALSE\t") {
+				t.Errorf("cookies.txt lacks the HttpOnly domain cookie:\n%s", data)
+			}
+
+			loaded := NewCookieJar()
+			if err := loaded.Load(path); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, cookie := range loaded.Cookies(u) {
+				got = append(got, cookie.Name+"="+cookie.Value)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("loaded cookies = %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieJarLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	os.WriteFile(path, []byte("example.com\tFALSE\t/\n"), 0o600)
+	if err := NewCookieJar().Load(path); err == nil {
+		t.Errorf("Load() accepted a line with 3 fields")
+	}
+}
+
+func TestDownloadSharesCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			http.Redirect(w, r, "/welcome.html", http.StatusFound)
+		default:
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+				http.Error(w, "log in first", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("private"))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	opts := Options{Directory: dir, Tries: 1, HTTP: NewHTTPClient(HTTPConfig{Cookies: NewCookieJar()})}
+	if _, err := Download(context.Background(), Request{URL: server.URL + "/login"}, opts); err != nil {
+		t.Fatalf("login Download() error = %v", err)
+	}
+	if _, err := Download(context.Background(), Request{URL: server.URL + "/private.txt"}, opts); err != nil {
+		t.Fatalf("Download() after login error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "private.txt")); string(data) != "private" {
+		t.Errorf("saved %q, want the private file", data)
+	}
+}
@@ -1,6 +1,8 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,142 +15,424 @@ import (
 	"wiget/internal/rateLimiter"
 )
 
-func OneDownload(file, url, limit, directory string) {
-	path := ExpandPath(directory)
-	fileURL := url
-	startTime := time.Now()
+// OneDownload downloads url to file, printing its progress. The returned error
+// has already been reported to the user.
+func OneDownload(ctx context.Context, file, url string, opts Options) error {
 	toDisplay, err := background.LoadShowProgressState()
 	if err != nil {
 		fmt.Println(err)
-		return
+		return err
 	}
-	fmt.Printf("start at %s\n", startTime.Format("2006-01-02 15:04:05"))
-
-	resp, err := HttpRequest(fileURL)
-	if err != nil {
-		fmt.Println("Error downloading file:", err)
-		return
+	opts.Log = os.Stdout
+	if toDisplay {
+		opts.Progress = ProgressBar(os.Stdout)
 	}
-	defer resp.Body.Close()
+	_, err = Download(ctx, Request{URL: url, Output: file}, opts)
+	return err
+}
+
+// Download fetches req.URL into the directory of opts and reports what it did.
+// Messages, errors included, are written to opts.Log. Cancelling ctx stops the
+// transfer and leaves a partial file that -c can resume, unless
+// opts.RemovePartial is set. When req.URL fails its mirrors are tried in
+// turn, saving to the same file. The outcome is added to opts.Report.
+func Download(ctx context.Context, req Request, opts Options) (Result, error) {
+	result, err := downloadMirrors(ctx, req, req.options(opts))
+	opts.Report.Add(req.URL, result, err)
+	return result, err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Error: status %s url: [%s]\n", resp.Status, url)
-		return
+// downloadMirrors fetches req.URL, or else the first of its mirrors that works
+func downloadMirrors(ctx context.Context, req Request, opts Options) (Result, error) {
+	if len(req.Mirrors) == 0 {
+		return download(ctx, req, opts)
 	}
-	fmt.Printf("sending request, awaiting response... status %s\n", resp.Status)
+	if req.Output == "" {
+		req.Output = FileNameFromURL(SourceURL(req.URL))
+	}
+	var result Result
+	var err error
+	for i, url := range append([]string{req.URL}, req.Mirrors...) {
+		if i > 0 {
+			fmt.Fprintf(opts.log(), "trying mirror %s\n", RedactURL(url))
+		}
+		req.URL = url
+		if result, err = download(ctx, req, opts); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return result, err
+}
 
-	contentLength := resp.ContentLength
-	fmt.Printf("content size: %d bytes [~%.2fMB]\n", contentLength, float64(contentLength)/1000000)
+// download fetches one URL of req
+func download(ctx context.Context, req Request, opts Options) (Result, error) {
+	log := opts.log()
+	path := expandPath(log, opts.Directory)
+	fileURL := SourceURL(req.URL)
+	file := req.Output
+	startTime := time.Now()
+	fmt.Fprintf(log, "start at %s\n", startTime.Format("2006-01-02 15:04:05"))
 
-	// Set the output file name
-	var outputFile string
-	if file == "" {
-		urlParts := strings.Split(fileURL, "/")
-		file = urlParts[len(urlParts)-1]
-		outputFile = filepath.Join(path, file)
-	} else {
-		outputFile = filepath.Join(path, file)
+	// Set the output file name. Without one the name comes from the URL for
+	// now; the response may still name the file once a fresh download starts
+	named := file != ""
+	if !named {
+		file = FileNameFromURL(fileURL)
 	}
-	// Create the path if it doesn't exist
-	if path != "" {
-		err = os.MkdirAll(path, 0o755)
-		if err != nil {
-			fmt.Println("Error creating path:", err)
-			return
+	if opts.AdjustExtension {
+		file = savedHTMLName(path, file)
+	}
+	outputFile := filepath.Join(path, file)
+	result := Result{URL: fileURL, Path: outputFile}
+	rename := func(resp *http.Response) {
+		name := file
+		if !named {
+			name = ResponseFileName(resp, fileURL)
+		}
+		if opts.AdjustExtension {
+			name = htmlFileName(resp, name)
+		}
+		if name != file {
+			file, outputFile = name, filepath.Join(path, name)
+			result.Path = outputFile
 		}
 	}
-	temp := ""
-	if file != "" && directory != "" {
-		fmt.Printf("saving file to: %s%s\n", directory, file)
-	} else if path == "" && file != "" {
-		temp = "./"
-		fmt.Printf("saving file to: %s%s\n", temp, file)
-	} else {
-		temp = "./"
-		fmt.Printf("saving file to: %s%s\n", temp, file)
+	offSite := func() bool {
+		if !opts.StayOnSite || SameSite(result.FinalURL, fileURL) {
+			return false
+		}
+		fmt.Fprintf(log, "Skipping %s, redirected off the site to %s\n", RedactURL(fileURL), RedactURL(result.FinalURL))
+		return true
+	}
+	fail := func(prefix string, err error) (Result, error) {
+		result.Duration = time.Since(startTime)
+		if ctx.Err() != nil {
+			return interrupted(ctx, log, result, opts)
+		}
+		fmt.Fprintln(log, prefix, err)
+		return result, err
+	}
+	skip := func() (Result, error) {
+		result.Skipped = true
+		result.Duration = time.Since(startTime)
+		return result, nil
+	}
+	if !opts.Continue && SkipExisting(outputFile, opts) {
+		return skip()
 	}
 
-	out, err := os.Create(outputFile)
+	checksum := opts.Checksum
+	if req.Checksum != "" {
+		checksum = req.Checksum
+	}
+	sum, err := resolveChecksum(ctx, opts, checksum, fileURL)
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return
+		return fail("Error:", err)
 	}
-	defer out.Close()
 
-	var reader io.Reader
-	if limit != "" {
-		reader = rateLimiter.NewRateLimitedReader(resp.Body, limit) // Assuming rateLimiter is defined elsewhere
-	} else {
-		reader = resp.Body
-	}
+	// The file is written to a .part file; one left by an earlier run is either
+	// carried on from or cleaned up
+	adopted := opts.Continue && adoptFile(outputFile)
+	resumeAt := partialOffset(log, outputFile, opts.Continue)
 
-	buffer := make([]byte, 32*1024) // 32 KB buffer size
-	var downloaded int64
-	startDownload := time.Now()
+	// -N asks the server whether the copy on disk is still current
+	timestamping := opts.Timestamping && resumeAt == 0 && fileExists(outputFile)
 
-	if toDisplay {
-		fmt.Print("Downloading... ")
+	// --split fetches a fresh download over several connections when the
+	// server accepts ranges and the file is requested with a plain GET;
+	// otherwise the file comes down a single stream
+	if opts.Split > 1 && resumeAt == 0 && !timestamping && opts.client().plainGet() {
+		if size, probe := probeRanges(ctx, opts, fileURL); size > minSegmentSize {
+			header := probe.Header
+			result.FinalURL = FinalURL(probe, fileURL)
+			LogRedirects(log, probe)
+			rename(probe)
+			if offSite() || SkipExisting(outputFile, opts) {
+				return skip()
+			}
+			result.StatusCode, result.Status, result.Header = http.StatusOK, "200 OK", header
+			fmt.Fprintf(log, "sending request, awaiting response... status %s\n", result.Status)
+			if err := os.MkdirAll(filepath.Dir(outputFile), 0o755); err != nil {
+				return fail("Error creating path:", err)
+			}
+			fmt.Fprintf(log, "content size: %d bytes [~%.2fMB]\n", size, float64(size)/1000000)
+			fmt.Fprintf(log, "saving file to: %s\n", displayPath(opts.Directory, file))
+
+			err := splitDownload(ctx, outputFile, result.FinalURL, size, header, opts)
+			if opts.Progress != nil {
+				fmt.Fprintln(log)
+			}
+			if errors.Is(err, errRangeIgnored) && ctx.Err() == nil {
+				// Servers behind some CDNs advertise ranges and then ignore them
+				fmt.Fprintln(log, "server ignored the range requests, downloading in one stream")
+				RemovePartial(outputFile)
+			} else {
+				if err != nil {
+					return fail("Error downloading file:", err)
+				}
+				result.Bytes, result.Size = size, size
+				if result.Checksum, err = verifyDownload(log, outputFile, nil, sum, opts.Quarantine); err != nil {
+					return fail("Error:", err)
+				}
+				return finishDownload(log, result, startTime, opts)
+			}
+		}
 	}
-	for {
-		n, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			fmt.Println("Error reading response body:", err)
-			return
+
+	// Once an attempt has written to the file, later attempts carry on from
+	// the last byte instead of starting over
+	started := false
+	notModified := false
+	var v *verifier
+	err = Retry(ctx, opts, fileURL, func() error {
+		offset := resumeAt
+		if started {
+			offset = ResumeOffset(outputFile)
 		}
 
-		if n > 0 {
-			if _, err := out.Write(buffer[:n]); err != nil {
-				fmt.Println("Error writing to file:", err)
-				return
+		var resp *http.Response
+		if timestamping && !started {
+			resp, err = TimestampRequest(ctx, opts, fileURL, outputFile)
+		} else {
+			resp, err = ResumeRequest(ctx, opts, fileURL, outputFile, offset)
+		}
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		result.StatusCode, result.Status, result.Header = resp.StatusCode, resp.Status, resp.Header
+		result.FinalURL = FinalURL(resp, fileURL)
+		LogRedirects(log, resp)
+		if offSite() {
+			result.Skipped = true
+			return nil
+		}
+
+		if timestamping && !started && NotModified(resp, outputFile) {
+			notModified = true
+			return nil
+		}
+
+		if AlreadyComplete(resp, offset) {
+			result.UpToDate = true
+			result.Size = offset
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return NewStatusError(fileURL, resp)
+		}
+		fmt.Fprintf(log, "sending request, awaiting response... status %s\n", resp.Status)
+
+		if !started && offset == 0 {
+			rename(resp)
+			if SkipExisting(outputFile, opts) {
+				result.Skipped = true
+				return nil
 			}
-			// Update the downloaded size
-			downloaded += int64(n)
-
-			if toDisplay {
-				// Calculate and display the progress
-				progress := float64(downloaded) / float64(contentLength) * 50
-				speed := float64(downloaded) / time.Since(startDownload).Seconds()
-				timeRemaining := time.Duration(float64(contentLength-downloaded)/speed) * time.Second
-
-				// Update the same line with progress
-				fmt.Printf("\r %.2f KiB / %.2f KiB [", float64(downloaded)/1024, float64(contentLength)/1024)
-				for i := 0; i < 50; i++ {
-					if i < int(progress) {
-						fmt.Print("=")
-					} else {
-						fmt.Print(" ")
-					}
+		}
+
+		// Create the path if it doesn't exist
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0o755); err != nil {
+			return fmt.Errorf("error creating path: %w", err)
+		}
+
+		requested := offset
+		out, offset, err := OpenOutput(outputFile, resp, offset)
+		if err != nil {
+			return fmt.Errorf("error creating file: %w", err)
+		}
+		defer out.Close()
+		started = true
+		result.Resumed = offset > 0
+
+		// Hash the file as it is written, starting with any resumed bytes
+		v, err = newVerifier(sum, PartPath(outputFile), offset)
+		if err != nil {
+			return err
+		}
+		writer := v.writer(out)
+
+		// A chunked or streamed body has no Content-Length; it is read until
+		// the server closes it
+		contentLength := resp.ContentLength
+		totalLength := offset + contentLength
+		if contentLength < 0 {
+			totalLength = -1
+			fmt.Fprintln(log, "content size: unknown, streaming until the server closes the connection")
+		} else {
+			fmt.Fprintf(log, "content size: %d bytes [~%.2fMB]\n", totalLength, float64(totalLength)/1000000)
+		}
+		if offset > 0 && contentLength >= 0 {
+			fmt.Fprintf(log, "resuming at byte %d, %d bytes remaining\n", offset, contentLength)
+		} else if offset > 0 {
+			fmt.Fprintf(log, "resuming at byte %d\n", offset)
+		} else if requested > 0 {
+			fmt.Fprintln(log, "server does not support resuming, restarting download")
+		}
+
+		fmt.Fprintf(log, "saving file to: %s\n", displayPath(opts.Directory, file))
+
+		var reader io.Reader
+		if opts.RateLimit != "" {
+			reader = rateLimiter.NewRateLimitedReader(ctx, resp.Body, opts.RateLimit) // Assuming rateLimiter is defined elsewhere
+		} else {
+			reader = resp.Body
+		}
+
+		buffer := make([]byte, 32*1024) // 32 KB buffer size
+		var downloaded int64
+		startDownload := time.Now()
+
+		if opts.Progress != nil {
+			fmt.Fprint(log, "Downloading... ")
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := reader.Read(buffer)
+			if err != nil && err != io.EOF {
+				if opts.Progress != nil {
+					fmt.Fprintln(log)
+				}
+				return fmt.Errorf("error reading response body: %w", err)
+			}
+
+			if n > 0 {
+				if _, err := writer.Write(buffer[:n]); err != nil {
+					return fmt.Errorf("error writing to file: %w", err)
 				}
-				fmt.Printf("] %.2f%% %.2f KiB/s %s", (float64(downloaded)*100)/float64(contentLength), speed/1024, timeRemaining.String())
+				// Update the downloaded size
+				downloaded += int64(n)
+				result.Bytes += int64(n)
 
+				if opts.Progress != nil {
+					opts.Progress(Progress{Done: offset + downloaded, Total: totalLength, Downloaded: downloaded, Started: startDownload})
+				}
 			}
 
+			if contentLength >= 0 && downloaded >= contentLength {
+				break
+			}
+			if err == io.EOF {
+				// Ending before the declared length means the transfer was cut
+				if contentLength >= 0 {
+					if opts.Progress != nil {
+						fmt.Fprintln(log)
+					}
+					return fmt.Errorf("error reading response body: got %d of %d bytes: %w", downloaded, contentLength, io.ErrUnexpectedEOF)
+				}
+				break
+			}
+		}
+		if opts.Progress != nil {
+			fmt.Fprintln(log) // Move to the next line after download completes
+			fmt.Fprintln(log)
 		}
+		result.Size = offset + downloaded
+		return CloseOutput(out)
+	})
+	if err != nil {
+		if adopted && !started {
+			// Give the file back its name, it was never touched
+			os.Rename(PartPath(outputFile), outputFile)
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return fail("Error:", err)
+		}
+		return fail("Error downloading file:", err)
+	}
+	if result.Skipped {
+		return skip()
+	}
+	if notModified {
+		result.UpToDate = true
+		if info, err := os.Stat(outputFile); err == nil {
+			result.Size = info.Size()
+		}
+		result.Duration = time.Since(startTime)
+		fmt.Fprintf(log, "Server file no newer than local file %s -- not retrieving.\n", outputFile)
+		return result, nil
+	}
+	if result.UpToDate {
+		// Nothing was streamed, so check the copy already on disk
+		v = nil
+	}
+	if result.Checksum, err = verifyDownload(log, outputFile, v, sum, opts.Quarantine); err != nil {
+		return fail("Error:", err)
+	}
+	if result.UpToDate {
+		if err := FinishOutput(outputFile); err != nil {
+			return fail("Error:", err)
+		}
+		result.Duration = time.Since(startTime)
+		fmt.Fprintf(log, "The file is already fully retrieved; nothing to do.\n")
+		return result, nil
+	}
+	return finishDownload(log, result, startTime, opts)
+}
 
-		if downloaded >= contentLength {
-			break
+// interrupted cleans up after a download stopped by ctx. The partial file is
+// kept for -c, with its resume metadata, unless opts.RemovePartial is set.
+func interrupted(ctx context.Context, log io.Writer, result Result, opts Options) (Result, error) {
+	if HasPartial(result.Path) {
+		if opts.RemovePartial {
+			RemovePartial(result.Path)
+			fmt.Fprintf(log, "Interrupted, removed partial file %s\n", PartPath(result.Path))
+		} else {
+			fmt.Fprintf(log, "Interrupted, partial file kept at %s (resume with -c)\n", PartPath(result.Path))
 		}
 	}
-	if toDisplay {
-		fmt.Println() // Move to the next line after download completes
-		fmt.Println()
+	return result, fmt.Errorf("download interrupted: %w", ctx.Err())
+}
+
+// finishDownload moves the file of result into place, minding any file
+// already there, and reports the end of the download
+func finishDownload(log io.Writer, result Result, startTime time.Time, opts Options) (Result, error) {
+	path, err := PlaceOutput(result.Path, opts)
+	if err != nil {
+		result.Duration = time.Since(startTime)
+		fmt.Fprintln(log, "Error:", err)
+		return result, err
+	}
+	result.Path = path
+	if opts.Timestamping {
+		if err := SaveTimestamp(path, result.Header); err != nil {
+			fmt.Fprintln(log, "Warning: could not set the file time:", err)
+		}
 	}
 
 	endTime := time.Now()
-	fmt.Printf("Downloaded [%s]\n", fileURL)
-	fmt.Printf("finished at %s\n", endTime.Format("2006-01-02 15:04:05"))
-	if !toDisplay {
-		fmt.Println()
+	result.Duration = endTime.Sub(startTime)
+	fmt.Fprintf(log, "Downloaded [%s]\n", RedactURL(result.URL))
+	fmt.Fprintf(log, "finished at %s\n", endTime.Format("2006-01-02 15:04:05"))
+	if opts.Progress == nil {
+		fmt.Fprintln(log)
 	}
+	return result, nil
+}
+
+// displayPath is how the destination of a download is shown to the user
+func displayPath(directory, file string) string {
+	if file != "" && directory != "" {
+		return directory + file
+	}
+	return "./" + file
 }
 
 // ExpandPath expands shorthand notations to full paths
 func ExpandPath(path string) string {
+	return expandPath(os.Stdout, path)
+}
+
+// expandPath is ExpandPath, writing any error to log
+func expandPath(log io.Writer, path string) string {
 	// 1. Expand `~` to the home directory
 	if strings.HasPrefix(path, "~") {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			fmt.Println("Error finding home directory:", err)
+			fmt.Fprintln(log, "Error finding home directory:", err)
 			return ""
 		}
 		path = strings.Replace(path, "~", homeDir, 1)
@@ -160,7 +444,7 @@ func ExpandPath(path string) string {
 	// 3. Convert relative paths (./ or ../) to absolute paths
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		fmt.Println("Error getting absolute path:", err)
+		fmt.Fprintln(log, "Error getting absolute path:", err)
 		return ""
 	}
 
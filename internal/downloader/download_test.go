@@ -2,12 +2,18 @@ package downloader
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // captureOutput captures both stdout and stderr output from a function.
@@ -143,7 +149,7 @@ func TestOneDownload(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Capture the output printed to the terminal (stdout + stderr)
 			output, _ := captureOutput(func() {
-				OneDownload(tt.args.file, tt.args.url, tt.args.limit, tt.args.directory)
+				OneDownload(context.Background(), tt.args.file, tt.args.url, Options{RateLimit: tt.args.limit, Directory: tt.args.directory})
 			})
 
 			// Check if the output contains the expected message
@@ -227,3 +233,142 @@ func TestExpandPath(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadInterrupted(t *testing.T) {
+	half := bytes.Repeat([]byte("a"), 64*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(half)))
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(half)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // never send the second half
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		removePartial bool
+		wantFile      bool
+	}{
+		{name: "Partial file kept for -c", wantFile: true},
+		{name: "Partial file removed", removePartial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ctx, cancel := context.WithCancel(context.Background())
+			progress := func(p Progress) {
+				if p.Done >= int64(len(half)) {
+					cancel()
+				}
+			}
+
+			_, err := Download(ctx, Request{URL: server.URL + "/file.bin"}, Options{Directory: dir, Tries: 3, RemovePartial: tt.removePartial, Progress: progress})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Download() error = %v, want context.Canceled", err)
+			}
+
+			output := filepath.Join(dir, "file.bin")
+			if _, err := os.Stat(output); err == nil {
+				t.Errorf("%s exists, an unfinished download must stay in its .part file", output)
+			}
+			if _, err := os.Stat(PartPath(output)); (err == nil) != tt.wantFile {
+				t.Errorf("partial file exists = %v, want %v", err == nil, tt.wantFile)
+			}
+			if HasPartial(output) != tt.wantFile {
+				t.Errorf("HasPartial() = %v, want %v", HasPartial(output), tt.wantFile)
+			}
+			if tt.wantFile && ResumeOffset(output) != int64(len(half)) {
+				t.Errorf("ResumeOffset() = %d, want %d", ResumeOffset(output), len(half))
+			}
+		})
+	}
+}
+
+func TestDownloadRateLimitCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 64*1024))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Download(ctx, Request{URL: server.URL + "/file.bin", RateLimit: "1k"}, Options{Directory: t.TempDir(), Tries: 1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Download() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("Download() took %v to stop, want it to stop while waiting for the rate limit", elapsed)
+	}
+}
+
+func TestDownloadUnknownLength(t *testing.T) {
+	content := bytes.Repeat([]byte("stream "), 20000)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{
+			name: "Chunked body is read to the end",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < len(content); i += 10000 {
+					end := i + 10000
+					if end > len(content) {
+						end = len(content)
+					}
+					w.Write(content[i:end])
+					w.(http.Flusher).Flush()
+				}
+			},
+		},
+		{
+			name: "Short body is an error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				w.Write(content[:len(content)/3])
+				w.(http.Flusher).Flush()
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			dir := t.TempDir()
+			var totals []int64
+			progress := func(p Progress) {
+				totals = append(totals, p.Total)
+				printProgress(io.Discard, p)
+			}
+			res, err := Download(context.Background(), Request{URL: server.URL + "/stream.txt"}, Options{Directory: dir, Tries: 1, Progress: progress})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			output := filepath.Join(dir, "stream.txt")
+			got, readErr := os.ReadFile(output)
+			if tt.wantErr {
+				if readErr == nil {
+					t.Errorf("%s was saved from a short body", output)
+				}
+				return
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("saved %d bytes, want %d", len(got), len(content))
+			}
+			if res.Size != int64(len(content)) {
+				t.Errorf("Size = %d, want %d", res.Size, len(content))
+			}
+			if len(totals) == 0 || totals[0] != -1 {
+				t.Errorf("progress totals = %v, want -1 for an unknown size", totals)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Values of HTTPConfig.Compression (--compression)
+const (
+	CompressionAuto = "auto" // ask for every supported encoding and decode it
+	CompressionNone = "none" // ask for the plain body and save whatever comes
+	CompressionGzip = "gzip"
+	CompressionBr   = "br"
+	CompressionZstd = "zstd"
+)
+
+// acceptEncodings is the Accept-Encoding header sent for each setting
+var acceptEncodings = map[string]string{
+	CompressionAuto: "gzip, deflate, br, zstd",
+	CompressionNone: "identity",
+	CompressionGzip: "gzip",
+	CompressionBr:   "br",
+	CompressionZstd: "zstd",
+}
+
+// setAcceptEncoding negotiates the encoding of req. A HEAD request or, when
+// the body is decoded, a range request asks for the plain body so that sizes
+// and offsets match the file saved. An Accept-Encoding given with --header
+// is left alone.
+func (c *HTTPClient) setAcceptEncoding(req *http.Request, keepEncoded bool) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return
+	}
+	if req.Method == http.MethodHead || (req.Header.Get("Range") != "" && !keepEncoded) {
+		req.Header.Set("Accept-Encoding", "identity")
+		return
+	}
+	req.Header.Set("Accept-Encoding", acceptEncodings[c.config.Compression])
+}
+
+// decodeBody replaces the body of resp with its decoded content. The size of
+// the decoded body is not known, so ContentLength becomes -1.
+func decodeBody(resp *http.Response) error {
+	var encodings []string
+	for _, value := range resp.Header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	if len(encodings) == 0 || resp.Request.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	// Encodings are listed in the order they were applied, so the last one
+	// is undone first
+	decoded := &decodedBody{body: resp.Body}
+	var reader io.Reader = resp.Body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newDecoder(encodings[i], reader)
+		if err != nil {
+			return err
+		}
+		decoded.decoders = append(decoded.decoders, decoder)
+		reader = decoder
+	}
+	decoded.Reader = reader
+	resp.Body = decoded
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// newDecoder returns a reader undoing encoding. The decoder is only created
+// on the first read, so an error response that is never read costs nothing.
+func newDecoder(encoding string, r io.Reader) (*lazyReader, error) {
+	var open func() (io.Reader, error)
+	switch encoding {
+	case "gzip", "x-gzip":
+		open = func() (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		open = func() (io.Reader, error) { return openDeflate(r) }
+	case "br":
+		open = func() (io.Reader, error) { return brotli.NewReader(r), nil }
+	case "zstd":
+		open = func() (io.Reader, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	return &lazyReader{open: open}, nil
+}
+
+// openDeflate reads a "deflate" body, which should be zlib wrapped but is
+// sent as raw deflate data by some servers
+func openDeflate(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header is a multiple of 31 with the deflate method in its low bits
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// lazyReader opens its reader on the first Read
+type lazyReader struct {
+	open   func() (io.Reader, error)
+	reader io.Reader
+	err    error
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.reader == nil && l.err == nil {
+		l.reader, l.err = l.open()
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.reader.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	if closer, ok := l.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// decodedBody reads the decoded content and closes the decoders along with
+// the response body
+type decodedBody struct {
+	io.Reader
+	decoders []*lazyReader
+	body     io.ReadCloser
+}
+
+func (d *decodedBody) Close() error {
+	for _, decoder := range d.decoders {
+		decoder.Close()
+	}
+	return d.body.Close()
+}
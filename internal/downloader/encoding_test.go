@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encode compresses data with the Content-Encoding name
+func encode(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch name {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	default:
+		return data
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDownloadDecodesContent(t *testing.T) {
+	content := []byte(strings.Repeat("compressible page content\n", 200))
+
+	tests := []struct {
+		name        string
+		config      HTTPConfig
+		encoding    string // how the server encodes the body, "" for not at all
+		wantAccept  string
+		wantEncoded bool // the file keeps the encoded bytes
+	}{
+		{name: "gzip by default", encoding: "gzip", wantAccept: "gzip, deflate, br, zstd"},
+		{name: "zlib deflate", encoding: "deflate", wantAccept: "gzip, deflate, br, zstd"},
+		{name: "Raw deflate", encoding: "raw-deflate", wantAccept: "gzip, deflate, br, zstd"},
+		{name: "Brotli only", config: HTTPConfig{Compression: CompressionBr}, encoding: "br", wantAccept: "br"},
+		{name: "zstd only", config: HTTPConfig{Compression: CompressionZstd}, encoding: "zstd", wantAccept: "zstd"},
+		{name: "No compression", config: HTTPConfig{Compression: CompressionNone}, wantAccept: "identity"},
+		{name: "Encoded body kept", config: HTTPConfig{Compression: CompressionGzip, KeepEncoded: true}, encoding: "gzip", wantAccept: "gzip", wantEncoded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encode(t, tt.encoding, content)
+			var accept string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accept = r.Header.Get("Accept-Encoding")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", strings.TrimPrefix(tt.encoding, "raw-"))
+				}
+				w.Write(encoded)
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 1, HTTP: NewHTTPClient(tt.config)}
+			if _, err := Download(context.Background(), Request{URL: server.URL + "/page.html"}, opts); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if accept != tt.wantAccept {
+				t.Errorf("Accept-Encoding = %q, want %q", accept, tt.wantAccept)
+			}
+			want := content
+			if tt.wantEncoded {
+				want = encoded
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "page.html")); !bytes.Equal(data, want) {
+				t.Errorf("saved %d bytes, want %d", len(data), len(want))
+			}
+		})
+	}
+}
+
+func TestDecodeStackedEncodings(t *testing.T) {
+	content := []byte("twice compressed")
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Encoding": {"gzip, br"}},
+		Body:          io.NopCloser(bytes.NewReader(encode(t, "br", encode(t, "gzip", content)))),
+		ContentLength: 42,
+		Request:       &http.Request{Method: "GET"},
+	}
+	if err := decodeBody(resp); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("decoded %q, %v; want %q", data, err, content)
+	}
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("decoded response still describes the encoded body: %d %v", resp.ContentLength, resp.Header)
+	}
+
+	resp.Header.Set("Content-Encoding", "compress")
+	if err := decodeBody(resp); err == nil {
+		t.Errorf("decodeBody() accepted an unsupported encoding")
+	}
+}
+
+func TestResumeAsksForPlainBody(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	var mu sync.Mutex
+	var accepts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		accepts = append(accepts, r.Header.Get("Range")+" "+r.Header.Get("Accept-Encoding"))
+		mu.Unlock()
+		http.ServeContent(w, r, "file.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(PartPath(filepath.Join(dir, "file.txt")), content[:400], 0o644)
+	opts := Options{Directory: dir, Tries: 1, Continue: true}
+	if _, err := Download(context.Background(), Request{URL: server.URL + "/file.txt"}, opts); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "file.txt")); !bytes.Equal(data, content) {
+		t.Errorf("resumed file differs from the original")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(accepts) != 1 || accepts[0] != "bytes=400- identity" {
+		t.Errorf("requests = %q, want a plain range request", accepts)
+	}
+}
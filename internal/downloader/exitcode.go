@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Exit statuses of a run, the same as wget's
+const (
+	ExitOK       = 0
+	ExitGeneric  = 1 // any other failure, such as a checksum mismatch
+	ExitParse    = 2 // the command line could not be parsed
+	ExitIO       = 3 // a local file could not be read or written
+	ExitNetwork  = 4 // DNS failure, refused or dropped connection, timeout
+	ExitTLS      = 5 // the certificate of the server could not be verified
+	ExitAuth     = 6 // the server refused the credentials
+	ExitProtocol = 7 // the server broke the protocol, e.g. a bad redirect
+	ExitServer   = 8 // the server answered with an error status
+)
+
+// ExitCode returns the exit status that err calls for, ExitOK for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	// The errors of the downloader itself come first: most of them reach
+	// here wrapped in a *url.Error, which is also a net.Error
+	if errors.Is(err, errHTTPSDowngrade) || errors.Is(err, errLocalRedirect) || errors.Is(err, errRedirectLimit) {
+		return ExitProtocol
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &invalidCert) ||
+		errors.As(err, &verifyErr) || errors.Is(err, errPinMismatch) {
+		return ExitTLS
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusProxyAuthRequired:
+			return ExitAuth
+		}
+		return ExitServer
+	}
+
+	// Only failures of the network itself, not any error a request ended with
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ExitNetwork
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.ENOSPC) {
+		return ExitIO
+	}
+	return ExitGeneric
+}
+
+// WorseExitCode returns whichever of two exit statuses a run should end
+// with. As in wget, any failure beats ExitOK and ExitGeneric, and among the
+// others the lower status wins.
+func WorseExitCode(a, b int) int {
+	switch {
+	case a == ExitOK:
+		return b
+	case b == ExitOK:
+		return a
+	case a == ExitGeneric:
+		return b
+	case b == ExitGeneric:
+		return a
+	}
+	return min(a, b)
+}
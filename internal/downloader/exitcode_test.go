@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "No error", err: nil, want: ExitOK},
+		{name: "Not found", err: &StatusError{StatusCode: 404}, want: ExitServer},
+		{name: "Server error", err: &StatusError{StatusCode: 503}, want: ExitServer},
+		{name: "Unauthorized", err: &StatusError{StatusCode: 401}, want: ExitAuth},
+		{name: "Proxy authentication", err: &StatusError{StatusCode: 407}, want: ExitAuth},
+		{name: "Unknown host", err: &url.Error{Op: "Get", URL: "https://nowhere.example", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, want: ExitNetwork},
+		{name: "Connection refused", err: fmt.Errorf("error sending request: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), want: ExitNetwork},
+		{name: "Short body", err: fmt.Errorf("error reading response body: %w", io.ErrUnexpectedEOF), want: ExitNetwork},
+		{name: "Untrusted certificate", err: &url.Error{Op: "Get", URL: "https://self-signed.example", Err: x509.UnknownAuthorityError{}}, want: ExitTLS},
+		{name: "Pinned key mismatch", err: fmt.Errorf("error sending request: %w", errPinMismatch), want: ExitTLS},
+		{name: "HTTPS downgrade", err: &url.Error{Op: "Get", URL: "https://example.com", Err: errHTTPSDowngrade}, want: ExitProtocol},
+		{name: "Too many redirects", err: fmt.Errorf("20 %w", errRedirectLimit), want: ExitProtocol},
+		{name: "Cannot create file", err: fmt.Errorf("error creating file: %w", &fs.PathError{Op: "open", Path: "/ro/file", Err: os.ErrPermission}), want: ExitIO},
+		{name: "Timeout", err: &url.Error{Op: "Get", URL: "https://slow.example", Err: timeoutError{}}, want: ExitNetwork},
+		{name: "Unsupported scheme", err: &url.Error{Op: "Get", URL: "gopher://example.com", Err: errors.New("unsupported protocol scheme")}, want: ExitGeneric},
+		{name: "Missing local file", err: &url.Error{Op: "Get", URL: "file:///missing", Err: &fs.PathError{Op: "open", Path: "/missing", Err: fs.ErrNotExist}}, want: ExitIO},
+		{name: "Not found after a redirect", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &StatusError{StatusCode: 404}}, want: ExitServer},
+		{name: "Checksum mismatch", err: &ChecksumError{File: "file.iso"}, want: ExitGeneric},
+		{name: "Interrupted", err: context.Canceled, want: ExitGeneric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWorseExitCode(t *testing.T) {
+	tests := []struct {
+		a, b int
+		want int
+	}{
+		{ExitOK, ExitOK, ExitOK},
+		{ExitOK, ExitGeneric, ExitGeneric},
+		{ExitGeneric, ExitServer, ExitServer},
+		{ExitServer, ExitGeneric, ExitServer},
+		{ExitServer, ExitNetwork, ExitNetwork},
+		{ExitAuth, ExitIO, ExitIO},
+	}
+	for _, tt := range tests {
+		if got := WorseExitCode(tt.a, tt.b); got != tt.want {
+			t.Errorf("WorseExitCode(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
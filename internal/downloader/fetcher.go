@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fetcher opens the content of a URL as a stream. The fetcher registered for
+// a scheme, or set in HTTPConfig.Fetchers, serves every download of that
+// scheme: its streams go through the same retry, resume, timestamping,
+// naming and checksum code as HTTP responses, so a new scheme needs no
+// change anywhere else.
+type Fetcher interface {
+	// Open returns the content of rawURL from r.Start on. A fetcher that
+	// cannot seek may return the content from an earlier offset, given in
+	// Stream.Offset, and the bytes before r.Start are skipped for it. A
+	// missing resource is reported with an error wrapping fs.ErrNotExist, a
+	// forbidden one with fs.ErrPermission; a *StatusError is reported with
+	// its status. Other errors are returned as they are and retried when
+	// IsRetryable says so.
+	Open(ctx context.Context, rawURL string, r ByteRange) (*Stream, error)
+}
+
+// FetcherFunc lets an ordinary function serve as a Fetcher
+type FetcherFunc func(ctx context.Context, rawURL string, r ByteRange) (*Stream, error)
+
+func (f FetcherFunc) Open(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+	return f(ctx, rawURL, r)
+}
+
+// ByteRange is the part of a resource asked for, from Start up to but not
+// including End. An End of 0 asks for the rest, so the zero value asks for
+// everything.
+type ByteRange struct {
+	Start, End int64
+}
+
+// Stream is the content of a URL and what is known about it
+type Stream struct {
+	Body        io.ReadCloser
+	Offset      int64     // position of the first byte of Body in the resource
+	Size        int64     // size of the whole resource, 0 when unknown
+	ContentType string    // guessed from the URL when empty
+	ModTime     time.Time // last modification, zero when unknown
+	Name        string    // file name suggested by the source, if any
+	URL         string    // where the content was served from after redirects, if known
+}
+
+// fetcherTransport serves the requests of the HTTP client with a Fetcher.
+// Ranges, If-Range and If-Modified-Since are answered here, so fetchers only
+// have to open streams.
+type fetcherTransport struct {
+	fetcher Fetcher
+}
+
+func (t fetcherTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("%s: method %s is not supported", req.URL.Scheme, req.Method)
+	}
+
+	var r ByteRange
+	if start, end, ranged := parseRange(req.Header.Get("Range")); ranged {
+		r.Start = start
+		if end >= 0 {
+			r.End = end + 1
+		}
+	}
+	stream, err := t.fetcher.Open(req.Context(), req.URL.String(), r)
+	if err != nil {
+		return fetchErrorResponse(req, err)
+	}
+	// A resource changed since the part we have was saved is sent whole
+	if ifRange := req.Header.Get("If-Range"); r != (ByteRange{}) && ifRange != "" && ifRange != lastModified(stream.ModTime) {
+		stream.Body.Close()
+		r = ByteRange{}
+		if stream, err = t.fetcher.Open(req.Context(), req.URL.String(), r); err != nil {
+			return fetchErrorResponse(req, err)
+		}
+	}
+	return streamResponse(req, stream, r)
+}
+
+// streamResponse answers req with the part r of stream
+func streamResponse(req *http.Request, stream *Stream, r ByteRange) (*http.Response, error) {
+	header := http.Header{"Accept-Ranges": {"bytes"}}
+	header.Set("Content-Type", stream.ContentType)
+	if stream.ContentType == "" {
+		header.Set("Content-Type", contentTypeByName(req.URL.Path))
+	}
+	if !stream.ModTime.IsZero() {
+		header.Set("Last-Modified", lastModified(stream.ModTime))
+	}
+	if stream.Name != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": stream.Name}))
+	}
+	resp := &http.Response{
+		Status: "200 OK", StatusCode: http.StatusOK,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: header, ContentLength: -1, Request: req, Body: http.NoBody,
+	}
+	noBody := func(status int) (*http.Response, error) {
+		stream.Body.Close()
+		resp.Status, resp.StatusCode, resp.ContentLength = fmt.Sprintf("%d %s", status, http.StatusText(status)), status, 0
+		return resp, nil
+	}
+
+	if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !stream.ModTime.IsZero() && !stream.ModTime.Truncate(time.Second).After(since) {
+		return noBody(http.StatusNotModified)
+	}
+	size := stream.Size
+	if size <= 0 {
+		size = -1 // unknown, as an empty body reads the same either way
+	}
+	if r.Start > 0 && size >= 0 && r.Start >= size {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return noBody(http.StatusRequestedRangeNotSatisfiable)
+	}
+	if stream.Offset > r.Start {
+		stream.Body.Close()
+		return nil, fmt.Errorf("%s: stream starts at byte %d, after the %d asked for", req.URL.Scheme, stream.Offset, r.Start)
+	}
+	if req.Method == http.MethodHead {
+		noBody(http.StatusOK)
+		if size >= 0 {
+			resp.ContentLength = size
+			header.Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		return resp, nil
+	}
+
+	var body io.Reader = stream.Body
+	if skip := r.Start - stream.Offset; skip > 0 {
+		if _, err := io.CopyN(io.Discard, body, skip); err != nil {
+			stream.Body.Close()
+			return nil, fmt.Errorf("%s: skipping to byte %d: %w", req.URL.Scheme, r.Start, err)
+		}
+	}
+	end := r.End
+	if size >= 0 && (end == 0 || end > size) {
+		end = size
+	}
+	switch {
+	case r == (ByteRange{}):
+		if size >= 0 {
+			resp.ContentLength = size
+			header.Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	case end > 0:
+		resp.Status, resp.StatusCode = "206 Partial Content", http.StatusPartialContent
+		resp.ContentLength = end - r.Start
+		body = io.LimitReader(body, resp.ContentLength)
+		header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", r.Start, end-1, sizeOrStar(size)))
+	default:
+		resp.Status, resp.StatusCode, resp.ContentLength = "206 Partial Content", http.StatusPartialContent, -1
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-/*", r.Start))
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, stream.Body}
+	return resp, nil
+}
+
+// fetchErrorResponse turns the error of a Fetcher into the HTTP status it
+// stands for, so that it is reported, and retried or not, like one
+func fetchErrorResponse(req *http.Request, err error) (*http.Response, error) {
+	status := 0
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		status = statusErr.StatusCode
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		status = http.StatusForbidden
+	default:
+		return nil, err
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  http.Header{},
+		Body:    http.NoBody,
+		Request: req,
+	}, nil
+}
+
+func lastModified(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// Open makes c a Fetcher for every scheme it serves, for callers that want
+// the content of a URL without handling HTTP responses. Any status other
+// than 200 or 206 is returned as a *StatusError.
+func (c *HTTPClient) Open(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+	header := http.Header{}
+	if r.End > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Start, r.End-1))
+	} else if r.Start > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.Start))
+	}
+	resp, err := c.do(ctx, "GET", rawURL, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &Stream{
+		Body:        resp.Body,
+		Size:        max(resp.ContentLength, 0),
+		ContentType: resp.Header.Get("Content-Type"),
+		Name:        sanitizeFileName(dispositionFileName(resp.Header.Get("Content-Disposition"))),
+		URL:         FinalURL(resp, rawURL),
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		stream.ModTime = modTime
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		if stream.Offset, err = contentRangeStart(resp.Header.Get("Content-Range")); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		stream.Size = max(contentRangeSize(resp.Header.Get("Content-Range")), 0)
+	default:
+		resp.Body.Close()
+		return nil, NewStatusError(rawURL, resp)
+	}
+	return stream, nil
+}
+
+// Open returns the content of rawURL, or part of it, with the client of opts
+func Open(ctx context.Context, opts Options, rawURL string, r ByteRange) (*Stream, error) {
+	return opts.client().Open(ctx, rawURL, r)
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFetcher serves content for every URL and records the ranges asked for
+type fakeFetcher struct {
+	content []byte
+	seek    bool // start the stream at the range asked for
+	name    string
+	modTime time.Time
+	err     error
+
+	mu     sync.Mutex
+	ranges []ByteRange
+}
+
+func (f *fakeFetcher) Open(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+	f.mu.Lock()
+	f.ranges = append(f.ranges, r)
+	f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	stream := &Stream{Size: int64(len(f.content)), Name: f.name, ModTime: f.modTime}
+	if f.seek && r.Start < int64(len(f.content)) {
+		stream.Offset = r.Start
+	}
+	stream.Body = io.NopCloser(bytes.NewReader(f.content[stream.Offset:]))
+	return stream, nil
+}
+
+func TestDownloadWithFetcher(t *testing.T) {
+	content := []byte(strings.Repeat("fetched content\n", 64))
+
+	tests := []struct {
+		name       string
+		fetcher    *fakeFetcher
+		partial    int // bytes already saved in the .part file
+		wantFile   string
+		wantErr    bool // the fetcher reports a missing file
+		wantRanges []ByteRange
+	}{
+		{
+			name:       "Whole file",
+			fetcher:    &fakeFetcher{content: content},
+			wantFile:   "data.bin",
+			wantRanges: []ByteRange{{}},
+		},
+		{
+			name:       "Resumed by a fetcher that cannot seek",
+			fetcher:    &fakeFetcher{content: content},
+			partial:    300,
+			wantFile:   "data.bin",
+			wantRanges: []ByteRange{{Start: 300}},
+		},
+		{
+			name:       "Resumed by a fetcher that seeks",
+			fetcher:    &fakeFetcher{content: content, seek: true},
+			partial:    300,
+			wantFile:   "data.bin",
+			wantRanges: []ByteRange{{Start: 300}},
+		},
+		{
+			name:       "Name suggested by the fetcher",
+			fetcher:    &fakeFetcher{content: content, name: "report.txt"},
+			wantFile:   "report.txt",
+			wantRanges: []ByteRange{{}},
+		},
+		{
+			name:       "Missing file is a 404 and not retried",
+			fetcher:    &fakeFetcher{err: fs.ErrNotExist},
+			wantErr:    true,
+			wantRanges: []ByteRange{{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.partial > 0 {
+				os.WriteFile(PartPath(filepath.Join(dir, "data.bin")), content[:tt.partial], 0o644)
+			}
+			// The fake replaces https for this client, so nothing leaves the machine
+			client := NewHTTPClient(HTTPConfig{Fetchers: map[string]Fetcher{"https": tt.fetcher}})
+			opts := Options{Directory: dir, Tries: 3, WaitRetry: time.Millisecond, Continue: tt.partial > 0, Log: &bytes.Buffer{}, HTTP: client}
+			_, err := Download(context.Background(), Request{URL: "https://example.com/files/data.bin"}, opts)
+
+			var statusErr *StatusError
+			if tt.wantErr {
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+					t.Errorf("Download() error = %v, want a 404", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if tt.wantFile != "" {
+				if data, _ := os.ReadFile(filepath.Join(dir, tt.wantFile)); !bytes.Equal(data, content) {
+					t.Errorf("%s holds %d bytes, want %d", tt.wantFile, len(data), len(content))
+				}
+			}
+			if len(tt.fetcher.ranges) != len(tt.wantRanges) {
+				t.Fatalf("fetcher was asked for %v, want %v", tt.fetcher.ranges, tt.wantRanges)
+			}
+			for i := range tt.wantRanges {
+				if tt.fetcher.ranges[i] != tt.wantRanges[i] {
+					t.Errorf("fetcher was asked for %v, want %v", tt.fetcher.ranges, tt.wantRanges)
+				}
+			}
+		})
+	}
+}
+
+func TestFetcherTimestamping(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fetcher := &fakeFetcher{content: []byte("daily report"), modTime: modified}
+	dir := t.TempDir()
+	client := NewHTTPClient(HTTPConfig{Fetchers: map[string]Fetcher{"mem": fetcher}})
+	opts := Options{Directory: dir, Tries: 1, Timestamping: true, Log: &bytes.Buffer{}, HTTP: client}
+	req := Request{URL: "mem://reports/report.txt"}
+
+	if _, err := Download(context.Background(), req, opts); err != nil {
+		t.Fatalf("first Download() error = %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "report.txt")); err != nil || !info.ModTime().Equal(modified) {
+		t.Fatalf("report.txt was not saved with the ModTime of the stream: %v", err)
+	}
+	result, err := Download(context.Background(), req, opts)
+	if err != nil || !result.UpToDate {
+		t.Errorf("second Download() = %+v, %v; want the unchanged file skipped", result, err)
+	}
+}
+
+func TestHTTPClientOpen(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "digits.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		r          ByteRange
+		wantOffset int64
+		want       []byte
+		wantErr    bool
+	}{
+		{name: "Whole resource", path: "/digits.txt", want: content},
+		{name: "From an offset", path: "/digits.txt", r: ByteRange{Start: 10}, wantOffset: 10, want: content[10:]},
+		{name: "Closed range", path: "/digits.txt", r: ByteRange{Start: 10, End: 25}, wantOffset: 10, want: content[10:25]},
+		{name: "Missing", path: "/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := Open(context.Background(), Options{}, server.URL+tt.path, tt.r)
+			var statusErr *StatusError
+			if tt.wantErr {
+				if !errors.As(err, &statusErr) {
+					t.Errorf("Open() error = %v, want a *StatusError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer stream.Body.Close()
+			data, _ := io.ReadAll(stream.Body)
+			if stream.Offset != tt.wantOffset || stream.Size != int64(len(content)) || !bytes.Equal(data, tt.want) {
+				t.Errorf("Open() = offset %d, size %d, %q; want offset %d, size %d, %q", stream.Offset, stream.Size, data, tt.wantOffset, len(content), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultFileName is used when neither the response nor the URL names the file
+const defaultFileName = "index.html"
+
+// maxFileNameLength keeps names within what common file systems accept
+const maxFileNameLength = 255
+
+// FileNameFromURL returns the name a download of rawURL is saved under when
+// nothing better is known: the last element of its path, without the query.
+func FileNameFromURL(rawURL string) string {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		p = u.Path
+	} else if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		return defaultFileName
+	}
+	if name := sanitizeFileName(path.Base(p)); name != "" {
+		return name
+	}
+	return defaultFileName
+}
+
+// htmlFileName adds .html to file when resp is an HTML page saved under
+// another extension
+func htmlFileName(resp *http.Response, file string) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" || hasHTMLExtension(file) {
+		return file
+	}
+	return file + ".html"
+}
+
+// savedHTMLName returns file with .html added when an earlier run saved it
+// that way, so that -c, -N and --no-clobber find the copy in dir
+func savedHTMLName(dir, file string) string {
+	if hasHTMLExtension(file) {
+		return file
+	}
+	adjusted := filepath.Join(dir, file+".html")
+	if fileExists(adjusted) || HasPartial(adjusted) {
+		return file + ".html"
+	}
+	return file
+}
+
+func hasHTMLExtension(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".html" || ext == ".htm"
+}
+
+// ResponseFileName returns the name for the file in resp, the answer to a
+// request for rawURL. The name the server gives in Content-Disposition wins,
+// then the last element of the URL after any redirects.
+func ResponseFileName(resp *http.Response, rawURL string) string {
+	if name := sanitizeFileName(dispositionFileName(resp.Header.Get("Content-Disposition"))); name != "" {
+		return name
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		return FileNameFromURL(resp.Request.URL.String())
+	}
+	return FileNameFromURL(rawURL)
+}
+
+// dispositionFileName reads the filename of a Content-Disposition header,
+// preferring the RFC 5987 filename* form. Headers that do not parse cleanly,
+// such as an unquoted name holding a "/", are read leniently.
+func dispositionFileName(value string) string {
+	if value == "" {
+		return ""
+	}
+	if _, params, err := mime.ParseMediaType(value); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+
+	var plain string
+	parts := strings.Split(value, ";")
+	for _, part := range parts[1:] {
+		key, val, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "filename*":
+			if name := decodeExtValue(strings.Trim(val, `"`)); name != "" {
+				return name
+			}
+		case "filename":
+			plain = strings.Trim(val, `"`)
+		}
+	}
+	return plain
+}
+
+// decodeExtValue decodes an RFC 5987 value such as UTF-8'en'na%C3%AFve.txt.
+// Only the UTF-8 and ISO-8859-1 character sets are understood.
+func decodeExtValue(value string) string {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		if utf8.ValidString(raw) {
+			return raw
+		}
+	case "iso-8859-1":
+		runes := make([]rune, len(raw))
+		for i := 0; i < len(raw); i++ {
+			runes[i] = rune(raw[i])
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+// sanitizeFileName makes a name chosen by the server safe to create in the
+// download directory: directories are dropped, so "../../etc/passwd" becomes
+// "passwd", and control or reserved characters are replaced. It returns ""
+// when nothing usable is left.
+func sanitizeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
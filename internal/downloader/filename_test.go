@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://example.com/files/report.pdf", want: "report.pdf"},
+		{url: "https://example.com/download?id=5", want: "download"},
+		{url: "https://example.com/a/b.tar.gz#top", want: "b.tar.gz"},
+		{url: "https://example.com/dir/", want: "index.html"},
+		{url: "https://example.com", want: "index.html"},
+		{url: "https://example.com/na%C3%AFve%20file.txt", want: "naïve file.txt"},
+		{url: "https://example.com/a/%2e%2e", want: "index.html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := FileNameFromURL(tt.url); got != tt.want {
+				t.Errorf("FileNameFromURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dispositionFileName(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "Quoted filename", value: `attachment; filename="report.pdf"`, want: "report.pdf"},
+		{name: "RFC 5987 wins over the plain name", value: `attachment; filename="plain.txt"; filename*=UTF-8''na%C3%AFve%20file.txt`, want: "naïve file.txt"},
+		{name: "ISO-8859-1", value: `attachment; filename*=iso-8859-1'en'%A3%20rates.txt`, want: "£ rates.txt"},
+		{name: "Unquoted path", value: `attachment; filename=../../etc/passwd`, want: "../../etc/passwd"},
+		{name: "No filename", value: `inline`, want: ""},
+		{name: "No header", value: ``, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dispositionFileName(tt.value); got != tt.want {
+				t.Errorf("dispositionFileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "report.pdf", want: "report.pdf"},
+		{name: "../../etc/passwd", want: "passwd"},
+		{name: `..\..\windows\win.ini`, want: "win.ini"},
+		{name: "a:b*c?.txt", want: "a_b_c_.txt"},
+		{name: "bell\a.txt", want: "bell_.txt"},
+		{name: "..", want: ""},
+		{name: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFileName(tt.name); got != tt.want {
+				t.Errorf("sanitizeFileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadFileName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename="../report.pdf"`)
+			w.Write([]byte("pdf"))
+		case "/latest":
+			http.Redirect(w, r, "/releases/tool-1.2.tar.gz?sig=abc", http.StatusFound)
+		case "/releases/tool-1.2.tar.gz":
+			w.Write([]byte("tarball"))
+		default:
+			w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		output string
+		want   string
+	}{
+		{name: "Content-Disposition", url: "/download?id=5", want: "report.pdf"},
+		{name: "Final URL of a redirect", url: "/latest", want: "tool-1.2.tar.gz"},
+		{name: "Directory URL", url: "/docs/", want: "index.html"},
+		{name: "Explicit output name", url: "/download?id=5", output: "mine.pdf", want: "mine.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			res, err := Download(context.Background(), Request{URL: server.URL + tt.url, Output: tt.output}, Options{Directory: dir})
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			want := filepath.Join(dir, tt.want)
+			if res.Path != want {
+				t.Errorf("Path = %q, want %q", res.Path, want)
+			}
+			if _, err := os.Stat(want); err != nil {
+				t.Errorf("%s was not saved: %v", want, err)
+			}
+		})
+	}
+}
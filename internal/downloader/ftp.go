@@ -0,0 +1,842 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anonymousPassword is sent with the anonymous login, as wget does
+const anonymousPassword = "wiget@"
+
+// ftpTransport serves ftp:// and ftps:// URLs to the HTTP client, so FTP
+// downloads go through the same retry, resume, timestamping and --split code
+// as HTTP ones. Ranges become REST, MDTM becomes Last-Modified, a directory
+// is answered with an HTML index and FTP errors with the closest HTTP status.
+type ftpTransport struct {
+	dialer   *net.Dialer
+	config   HTTPConfig
+	netrc    []netrcEntry
+	sessions tls.ClientSessionCache // lets data connections resume the control session
+}
+
+func newFTPTransport(dialer *net.Dialer, config HTTPConfig, netrc []netrcEntry) *ftpTransport {
+	return &ftpTransport{dialer: dialer, config: config, netrc: netrc, sessions: tls.NewLRUClientSessionCache(0)}
+}
+
+// dirEntry is a file or directory of a listing
+type dirEntry struct {
+	Name    string
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+}
+
+func (t *ftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("ftp: method %s is not supported", req.Method)
+	}
+	name, err := ftpPath(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	c, err := t.connect(req.Context(), req.URL, requestOrigin(req))
+	if err != nil {
+		return ftpErrorResponse(req, err)
+	}
+
+	if name == "" || strings.HasSuffix(req.URL.Path, "/") {
+		defer c.close()
+		entries, err := c.list(name)
+		if err != nil {
+			return ftpErrorResponse(req, err)
+		}
+		return listingResponse(req, entries), nil
+	}
+
+	size, err := c.size(name)
+	if err != nil {
+		// SIZE fails on directories, which are sent to their listing
+		if c.isDir(name) {
+			c.close()
+			return redirectResponse(req, req.URL.Path+"/"), nil
+		}
+		var ftpErr *textproto.Error
+		if errors.As(err, &ftpErr) && ftpErr.Code != 550 {
+			size = -1 // SIZE is not supported, the file may still be there
+		} else {
+			c.close()
+			return ftpErrorResponse(req, err)
+		}
+	}
+	modTime := c.modTime(name)
+
+	header := http.Header{}
+	header.Set("Content-Type", contentTypeByName(name))
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if size >= 0 {
+		header.Set("Accept-Ranges", "bytes")
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	resp := &http.Response{
+		Status: "200 OK", StatusCode: http.StatusOK,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: header, ContentLength: size, Request: req, Body: http.NoBody,
+	}
+
+	if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !modTime.IsZero() && !modTime.Truncate(time.Second).After(since) {
+		c.close()
+		resp.Status, resp.StatusCode, resp.ContentLength = "304 Not Modified", http.StatusNotModified, 0
+		return resp, nil
+	}
+	if req.Method == http.MethodHead {
+		c.close()
+		return resp, nil
+	}
+
+	// A range is served with REST, unless If-Range shows the file changed
+	start, end, ranged := parseRange(req.Header.Get("Range"))
+	if ifRange := req.Header.Get("If-Range"); ranged && ifRange != "" && ifRange != header.Get("Last-Modified") {
+		ranged = false
+	}
+	if ranged && size >= 0 && start >= size {
+		c.close()
+		resp.Status, resp.StatusCode, resp.ContentLength = "416 Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable, 0
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return resp, nil
+	}
+	if ranged && start > 0 {
+		if _, _, err := c.cmd(3, "REST %d", start); err != nil {
+			ranged = false
+		}
+	}
+
+	data, err := c.openData("RETR " + name)
+	if err != nil {
+		c.close()
+		return ftpErrorResponse(req, err)
+	}
+	body := &ftpBody{Reader: data, data: data, conn: c}
+	resp.Body = body
+
+	if ranged && (start > 0 || end >= 0) {
+		if size >= 0 && (end < 0 || end >= size) {
+			end = size - 1
+		}
+		resp.Status, resp.StatusCode = "206 Partial Content", http.StatusPartialContent
+		if end >= 0 {
+			resp.ContentLength = end - start + 1
+			body.Reader = io.LimitReader(data, resp.ContentLength)
+			body.partial = true
+			resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, sizeOrStar(size)))
+		} else {
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-/*", start))
+		}
+	}
+	return resp, nil
+}
+
+// List returns the entries of the FTP directory at u
+func (t *ftpTransport) List(ctx context.Context, u *url.URL) ([]dirEntry, error) {
+	name, err := ftpPath(u)
+	if err != nil {
+		return nil, err
+	}
+	c, err := t.connect(ctx, u, u)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.list(name)
+}
+
+// DownloadTree downloads the FTP directory at rawURL and everything below it,
+// as wget -r does. The files are saved under opts.Directory in a folder named
+// after the host, followed by the path of each file. Files that fail are
+// reported and skipped; the returned error counts them.
+func DownloadTree(ctx context.Context, rawURL string, opts Options) error {
+	log := opts.log()
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "ftp" && u.Scheme != "ftps") {
+		err = fmt.Errorf("recursive retrieval needs an ftp:// or ftps:// URL, got %s", RedactURL(rawURL))
+		fmt.Fprintln(log, "Error:", err)
+		return err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	dir := filepath.Join(append([]string{opts.Directory, u.Hostname()}, strings.Split(strings.Trim(u.Path, "/"), "/")...)...)
+
+	failed, err := downloadTree(ctx, u, dir, opts)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files of %s could not be downloaded", failed, RedactURL(rawURL))
+	}
+	return nil
+}
+
+// downloadTree downloads the directory u into dir and returns how many files
+// failed. An error means the listing itself could not be read.
+func downloadTree(ctx context.Context, u *url.URL, dir string, opts Options) (int, error) {
+	log := opts.log()
+	fmt.Fprintf(log, "listing %s\n", RedactURL(u.String()))
+	var entries []dirEntry
+	err := Retry(ctx, opts, u.String(), func() error {
+		var err error
+		entries, err = opts.client().ftp.List(ctx, u)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintln(log, "Error listing directory:", err)
+		return 0, err
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+		// A name the server made up to climb out of dir is skipped
+		if sanitizeFileName(entry.Name) != entry.Name {
+			fmt.Fprintf(log, "Skipping unsafe name %q\n", entry.Name)
+			continue
+		}
+		child := *u
+		child.Path = u.Path + entry.Name
+		child.RawPath = ""
+		if entry.Dir {
+			child.Path += "/"
+			n, err := downloadTree(ctx, &child, filepath.Join(dir, entry.Name), opts)
+			failed += n
+			if err != nil {
+				if ctx.Err() != nil {
+					return failed, err
+				}
+				failed++
+			}
+			continue
+		}
+		fileOpts := opts
+		fileOpts.Directory = dir
+		if _, err := Download(ctx, Request{URL: child.String(), Output: entry.Name}, fileOpts); err != nil {
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// ftpConn is a logged in FTP control connection
+type ftpConn struct {
+	raw  net.Conn // the TCP connection, closed to abort
+	conn net.Conn
+	text *textproto.Conn
+	tls  *tls.Config // set for FTPS, whose data connections are encrypted too
+	t    *ftpTransport
+	stop func() bool // stops closing the connection when the context ends
+	mu   sync.Mutex
+	data net.Conn // the data connection in use, closed along with conn
+}
+
+// connect opens a control connection to the server of u and logs in with
+// the credentials t.credentials picks for it. origin is the URL the
+// download started at, before any redirect.
+func (t *ftpTransport) connect(ctx context.Context, u, origin *url.URL) (*ftpConn, error) {
+	implicit := u.Scheme == "ftps" && t.config.FTPSImplicit
+	port := "21"
+	if implicit {
+		port = "990"
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	raw, err := t.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{raw: raw, conn: &idleTimeoutConn{Conn: raw, timeout: t.config.ReadTimeout}, t: t}
+	c.stop = context.AfterFunc(ctx, c.abort)
+	fail := func(err error) (*ftpConn, error) {
+		c.abort()
+		c.stop()
+		return nil, err
+	}
+
+	if u.Scheme == "ftps" {
+		c.tls = &tls.Config{}
+		if t.config.TLS != nil {
+			c.tls = t.config.TLS.Clone()
+		}
+		if c.tls.ServerName == "" {
+			c.tls.ServerName = u.Hostname()
+		}
+		c.tls.ClientSessionCache = t.sessions
+	}
+	if implicit {
+		if c.conn, err = c.handshake(ctx, c.conn); err != nil {
+			return fail(err)
+		}
+	}
+	c.text = textproto.NewConn(c.conn)
+	if _, _, err := c.read(2); err != nil {
+		return fail(err)
+	}
+
+	if u.Scheme == "ftps" && !implicit {
+		if _, _, err := c.cmd(2, "AUTH TLS"); err != nil {
+			return fail(fmt.Errorf("server refused TLS: %w", err))
+		}
+		if c.conn, err = c.handshake(ctx, c.conn); err != nil {
+			return fail(err)
+		}
+		c.text = textproto.NewConn(c.conn)
+	}
+
+	user, password := t.credentials(u, origin)
+	code, msg, err := c.cmd(0, "USER %s", user)
+	if err == nil && code == 331 {
+		code, msg, err = c.cmd(0, "PASS %s", password)
+	}
+	if err == nil && code != 230 && code != 202 {
+		err = &textproto.Error{Code: code, Msg: msg}
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if c.tls != nil {
+		if _, _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return fail(err)
+		}
+		if _, _, err := c.cmd(2, "PROT P"); err != nil {
+			return fail(err)
+		}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return fail(err)
+	}
+	return c, nil
+}
+
+// credentials picks the login for u: that of the URL, of --user, of .netrc
+// or else anonymous
+func (t *ftpTransport) credentials(u, origin *url.URL) (string, string) {
+	if u.User != nil {
+		password, _ := u.User.Password()
+		return u.User.Username(), password
+	}
+	// As with HTTP, --user and the default of .netrc only go to the origin:
+	// a redirect must not send them in clear text to another server
+	home := sameOrigin(u, origin)
+	if home && t.config.User != "" {
+		return t.config.User, t.config.Password
+	}
+	if login, password, ok := lookupNetrc(t.netrc, u.Hostname(), home); ok {
+		return login, password
+	}
+	return "anonymous", anonymousPassword
+}
+
+func (c *ftpConn) handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Client(conn, c.tls)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// cmd sends a command and reads its reply, which must start with the digit
+// expect unless it is 0
+func (c *ftpConn) cmd(expect int, format string, args ...any) (int, string, error) {
+	line := fmt.Sprintf(format, args...)
+	if strings.ContainsAny(line, "\r\n") {
+		return 0, "", errors.New("ftp: line break in command")
+	}
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return 0, "", err
+	}
+	return c.read(expect)
+}
+
+func (c *ftpConn) read(expect int) (int, string, error) {
+	return c.text.ReadResponse(expect)
+}
+
+// size returns the size of the file name
+func (c *ftpConn) size(name string) (int64, error) {
+	_, msg, err := c.cmd(2, "SIZE %s", name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+}
+
+// modTime returns the modification time of the file name, zero when the
+// server does not tell
+func (c *ftpConn) modTime(name string) time.Time {
+	_, msg, err := c.cmd(2, "MDTM %s", name)
+	if err != nil {
+		return time.Time{}
+	}
+	return parseFTPTime(strings.TrimSpace(msg))
+}
+
+// isDir reports whether name is a directory the server lets us enter
+func (c *ftpConn) isDir(name string) bool {
+	_, _, err := c.cmd(2, "CWD %s", name)
+	return err == nil
+}
+
+// openData opens a data connection, passive unless --no-passive-ftp is set,
+// and sends command over the control connection to start the transfer
+func (c *ftpConn) openData(command string) (net.Conn, error) {
+	var data net.Conn
+	var err error
+	if c.t.config.FTPActive {
+		data, err = c.activeData(command)
+	} else {
+		data, err = c.passiveData(command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		tlsData := tls.Client(data, c.tls)
+		if err := tlsData.Handshake(); err != nil {
+			data.Close()
+			return nil, err
+		}
+		data = tlsData
+	}
+	c.mu.Lock()
+	c.data = data
+	c.mu.Unlock()
+	return data, nil
+}
+
+// passiveData asks the server for a port to connect to, with EPSV or else
+// PASV. The address announced by PASV is ignored in favour of the host of
+// the control connection, which also works behind NAT.
+func (c *ftpConn) passiveData(command string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	port := 0
+	if _, msg, err := c.cmd(2, "EPSV"); err == nil {
+		port, err = parseEPSV(msg)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, msg, err := c.cmd(2, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		if port, err = parsePASV(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := c.t.dialer.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := c.cmd(1, "%s", command); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return &idleTimeoutConn{Conn: raw, timeout: c.t.config.ReadTimeout}, nil
+}
+
+// activeData listens for the server to connect back, announced with EPRT or
+// else PORT
+func (c *ftpConn) activeData(command string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(c.conn.LocalAddr().String())
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	ip := net.ParseIP(host)
+	family := "2"
+	if ip.To4() != nil {
+		family = "1"
+	}
+	if _, _, err := c.cmd(2, "EPRT |%s|%s|%d|", family, host, port); err != nil {
+		if ip.To4() == nil {
+			return nil, err
+		}
+		octets := strings.ReplaceAll(ip.To4().String(), ".", ",")
+		if _, _, err := c.cmd(2, "PORT %s,%d,%d", octets, port>>8, port&0xff); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := c.cmd(1, "%s", command); err != nil {
+		return nil, err
+	}
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(c.t.config.ConnectTimeout))
+	raw, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &idleTimeoutConn{Conn: raw, timeout: c.t.config.ReadTimeout}, nil
+}
+
+// list reads the directory dir with MLSD, or LIST when the server lacks it
+func (c *ftpConn) list(dir string) ([]dirEntry, error) {
+	command := "MLSD"
+	parse := parseMLSD
+	if dir != "" {
+		command += " " + dir
+	}
+	data, err := c.openData(command)
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) && ftpErr.Code >= 500 && ftpErr.Code != 550 {
+		command, parse = "LIST", parseLIST
+		if dir != "" {
+			command += " " + dir
+		}
+		data, err = c.openData(command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	listing, err := io.ReadAll(data)
+	data.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := c.read(2); err != nil {
+		return nil, err
+	}
+
+	var entries []dirEntry
+	for _, line := range strings.Split(string(listing), "\n") {
+		if entry, ok := parse(strings.TrimRight(line, "\r")); ok && entry.Name != "." && entry.Name != ".." {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// abort closes the connections without a goodbye, to stop a transfer
+func (c *ftpConn) abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data != nil {
+		c.data.Close()
+	}
+	c.raw.Close()
+}
+
+// close ends the session
+func (c *ftpConn) close() {
+	c.stop()
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.cmd(0, "QUIT")
+	c.abort()
+}
+
+// ftpBody is the body of a RETR response. A transfer that ends early, which
+// FTP only reports on the control connection, fails the read.
+type ftpBody struct {
+	io.Reader
+	data    net.Conn
+	conn    *ftpConn
+	partial bool // only a range of the file is read, the transfer is cut short
+	done    bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF && !b.partial && !b.done {
+		b.done = true
+		b.data.Close()
+		if _, _, replyErr := b.conn.read(2); replyErr != nil {
+			return n, fmt.Errorf("ftp transfer failed: %v: %w", replyErr, io.ErrUnexpectedEOF)
+		}
+	}
+	return n, err
+}
+
+func (b *ftpBody) Close() error {
+	b.data.Close()
+	if !b.done && !b.partial {
+		// The transfer was abandoned, the server may not answer QUIT
+		b.conn.stop()
+		b.conn.abort()
+		return nil
+	}
+	b.conn.close()
+	return nil
+}
+
+// ftpErrorResponse turns an FTP error reply into the matching HTTP response
+// so that it is reported, and retried or not, like an HTTP status. Other
+// errors, such as a dropped connection, are returned as they are.
+func ftpErrorResponse(req *http.Request, err error) (*http.Response, error) {
+	var ftpErr *textproto.Error
+	if !errors.As(err, &ftpErr) {
+		return nil, err
+	}
+	status := http.StatusBadRequest
+	switch {
+	case ftpErr.Code == 530 || ftpErr.Code == 332:
+		status = http.StatusUnauthorized
+	case ftpErr.Code == 550 || ftpErr.Code == 553:
+		status = http.StatusNotFound
+	case ftpErr.Code >= 400 && ftpErr.Code < 500:
+		// 4xx replies are transient in FTP
+		status = http.StatusServiceUnavailable
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s (%d %s)", status, http.StatusText(status), ftpErr.Code, ftpErr.Msg),
+		StatusCode: status,
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  http.Header{},
+		Body:    http.NoBody,
+		Request: req,
+	}, nil
+}
+
+// requestOrigin returns the URL of the request that the redirects leading
+// to req started from
+func requestOrigin(req *http.Request) *url.URL {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL
+}
+
+// redirectResponse sends the client on to location
+func redirectResponse(req *http.Request, location string) *http.Response {
+	return &http.Response{
+		Status: "301 Moved Permanently", StatusCode: http.StatusMovedPermanently,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  http.Header{"Location": {location}},
+		Body:    http.NoBody,
+		Request: req,
+	}
+}
+
+// listingResponse answers a directory URL with an HTML index of entries
+func listingResponse(req *http.Request, entries []dirEntry) *http.Response {
+	body := listingPage(req.URL, "", entries)
+	return &http.Response{
+		Status: "200 OK", StatusCode: http.StatusOK,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":   {"text/html; charset=utf-8"},
+			"Content-Length": {strconv.Itoa(len(body))},
+		},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
+}
+
+// listingPage renders an HTML index of the directory u. The links are the
+// entry names after prefix, which makes them relative to the page.
+func listingPage(u *url.URL, prefix string, entries []dirEntry) string {
+	var b strings.Builder
+	title := html.EscapeString("Index of " + u.Redacted())
+	fmt.Fprintf(&b, "<html><head><title>%s</title></head><body><h1>%s</h1><pre>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name
+		if entry.Dir {
+			name += "/"
+		}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>", html.EscapeString((&url.URL{Path: prefix + name}).EscapedPath()), html.EscapeString(name))
+		if !entry.ModTime.IsZero() {
+			fmt.Fprintf(&b, "  %s", entry.ModTime.UTC().Format("2006-01-02 15:04"))
+		}
+		if !entry.Dir {
+			fmt.Fprintf(&b, "  %d", entry.Size)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("</pre></body></html>\n")
+	return b.String()
+}
+
+// ftpPath returns the path of u relative to the login directory, as wget
+// reads it; "%2F" at its start makes it absolute
+func ftpPath(u *url.URL) (string, error) {
+	name := strings.TrimPrefix(u.Path, "/")
+	if strings.ContainsAny(name, "\r\n") {
+		return "", errors.New("ftp: line break in path")
+	}
+	return strings.TrimSuffix(name, "/"), nil
+}
+
+func contentTypeByName(name string) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// parseRange reads a "bytes=start-" or "bytes=start-end" Range header. end
+// is -1 when open.
+func parseRange(header string) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, -1, false
+	}
+	from, to, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, false
+	}
+	if to == "" {
+		return start, -1, true
+	}
+	end, err := strconv.ParseInt(to, 10, 64)
+	if err != nil || end < start {
+		return 0, -1, false
+	}
+	return start, end, true
+}
+
+func sizeOrStar(size int64) string {
+	if size < 0 {
+		return "*"
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// parseEPSV reads the port of "229 Entering Extended Passive Mode (|||6446|)"
+func parseEPSV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("ftp: invalid EPSV reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], msg[start+1:start+2])
+	if len(fields) != 5 {
+		return 0, fmt.Errorf("ftp: invalid EPSV reply %q", msg)
+	}
+	return strconv.Atoi(fields[3])
+}
+
+// parsePASV reads the port of "227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)"
+func parsePASV(msg string) (int, error) {
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	high, err1 := strconv.Atoi(fields[4])
+	low, err2 := strconv.Atoi(fields[5])
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	return high<<8 | low, nil
+}
+
+// parseFTPTime reads the YYYYMMDDHHMMSS[.sss] times of MDTM and MLSD
+func parseFTPTime(value string) time.Time {
+	value, _, _ = strings.Cut(value, ".")
+	t, err := time.Parse("20060102150405", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// parseMLSD reads a line such as "type=file;size=42;modify=20240101120000; name"
+func parseMLSD(line string) (dirEntry, bool) {
+	facts, name, found := strings.Cut(line, " ")
+	if !found || name == "" {
+		return dirEntry{}, false
+	}
+	entry := dirEntry{Name: name, Size: -1}
+	for _, fact := range strings.Split(facts, ";") {
+		key, value, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(key) {
+		case "type":
+			switch strings.ToLower(value) {
+			case "dir":
+				entry.Dir = true
+			case "cdir", "pdir":
+				return dirEntry{}, false
+			}
+		case "size":
+			entry.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			entry.ModTime = parseFTPTime(value)
+		}
+	}
+	return entry, true
+}
+
+// parseLIST reads a Unix "ls -l" line such as
+// "-rw-r--r--   1 ftp ftp  42 Jan 01 12:00 name". Symbolic links are listed
+// as files under their own name.
+func parseLIST(line string) (dirEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || len(fields[0]) < 10 {
+		return dirEntry{}, false
+	}
+	// The name is what follows the eight columns before it, spaces included
+	rest := line
+	for i := 0; i < 8; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[strings.IndexAny(rest, " \t"):]
+	}
+	name := strings.TrimLeft(rest, " \t")
+	if fields[0][0] == 'l' {
+		name, _, _ = strings.Cut(name, " -> ")
+	}
+	size, _ := strconv.ParseInt(fields[4], 10, 64)
+	return dirEntry{Name: name, Dir: fields[0][0] == 'd', Size: size, ModTime: parseLISTTime(fields[5], fields[6], fields[7])}, true
+}
+
+// parseLISTTime reads the "Jan 01 12:00" or "Jan 01 2020" date of a LIST line
+func parseLISTTime(month, day, timeOrYear string) time.Time {
+	if strings.Contains(timeOrYear, ":") {
+		now := time.Now().UTC()
+		t, err := time.Parse("Jan 2 15:04 2006", fmt.Sprintf("%s %s %s %d", month, day, timeOrYear, now.Year()))
+		if err != nil {
+			return time.Time{}
+		}
+		// Without a year the date is within the last six months
+		if t.After(now.AddDate(0, 0, 1)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t
+	}
+	t, err := time.Parse("Jan 2 2006", fmt.Sprintf("%s %s %s", month, day, timeOrYear))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
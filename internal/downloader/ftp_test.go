@@ -0,0 +1,461 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// ftpServer is a small FTP server over an in-memory file system. It speaks
+// passive and active mode, REST, SIZE, MDTM, MLSD and LIST, and explicit or
+// implicit TLS, and records the commands it receives.
+type ftpServer struct {
+	files    fstest.MapFS
+	user     string // with password, the only login accepted; anonymous when empty
+	password string
+	tls      *tls.Config // enables AUTH TLS, or TLS from the start with implicit
+	implicit bool
+	noMLSD   bool
+	noEPSV   bool
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// start serves s on a local port until the test ends and returns its address
+func (s *ftpServer) start(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// sent reports whether a command starting with prefix was received
+func (s *ftpServer) sent(prefix string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, command := range s.commands {
+		if strings.HasPrefix(command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	if s.implicit {
+		conn = tls.Server(conn, s.tls)
+	}
+	text := textproto.NewConn(conn)
+	reply := func(code int, msg string) { text.PrintfLine("%d %s", code, msg) }
+	reply(220, "test server ready")
+
+	var passive net.Listener
+	var active string
+	var rest int64
+	var protected, loggedIn bool
+	var user string
+	defer func() {
+		if passive != nil {
+			passive.Close()
+		}
+	}()
+
+	// openData connects the data channel of a transfer, announced with 150
+	openData := func() net.Conn {
+		var data net.Conn
+		var err error
+		if passive != nil {
+			passive.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+			data, err = passive.Accept()
+			passive.Close()
+			passive = nil
+		} else if active != "" {
+			data, err = net.Dial("tcp", active)
+			active = ""
+		} else {
+			reply(425, "use PASV or PORT first")
+			return nil
+		}
+		if err != nil {
+			reply(425, "cannot open data connection")
+			return nil
+		}
+		reply(150, "opening data connection")
+		if protected {
+			data = tls.Server(data, s.tls)
+		}
+		return data
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.TrimSpace(command+" "+arg))
+		s.mu.Unlock()
+		name := strings.TrimPrefix(path.Clean("/"+arg), "/")
+		if name == "" {
+			name = "."
+		}
+
+		switch command {
+		case "AUTH":
+			if s.tls == nil {
+				reply(502, "TLS not available")
+				continue
+			}
+			reply(234, "starting TLS")
+			conn = tls.Server(conn, s.tls)
+			text = textproto.NewConn(conn)
+			continue
+		case "USER":
+			user = arg
+			reply(331, "password please")
+			continue
+		case "PASS":
+			if (s.user == "" && user == "anonymous") || (user == s.user && arg == s.password) {
+				loggedIn = true
+				reply(230, "logged in")
+			} else {
+				reply(530, "Login incorrect.")
+			}
+			continue
+		case "QUIT":
+			reply(221, "bye")
+			return
+		}
+		if !loggedIn {
+			reply(530, "please log in")
+			continue
+		}
+
+		info, statErr := fs.Stat(s.files, name)
+		switch command {
+		case "PBSZ", "TYPE":
+			reply(200, "ok")
+		case "PROT":
+			protected = arg == "P"
+			reply(200, "ok")
+		case "SIZE":
+			if statErr != nil || info.IsDir() {
+				reply(550, "not a plain file")
+			} else {
+				reply(213, strconv.FormatInt(info.Size(), 10))
+			}
+		case "MDTM":
+			if statErr != nil {
+				reply(550, "no such file")
+			} else {
+				reply(213, info.ModTime().UTC().Format("20060102150405"))
+			}
+		case "CWD":
+			if statErr != nil || !info.IsDir() {
+				reply(550, "no such directory")
+			} else {
+				reply(250, "ok")
+			}
+		case "EPSV", "PASV":
+			if command == "EPSV" && s.noEPSV {
+				reply(502, "EPSV not implemented")
+				continue
+			}
+			passive, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := passive.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+			} else {
+				reply(227, fmt.Sprintf("Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff))
+			}
+		case "EPRT":
+			fields := strings.Split(arg, "|")
+			if len(fields) != 5 {
+				reply(501, "bad EPRT")
+				continue
+			}
+			active = net.JoinHostPort(fields[2], fields[3])
+			reply(200, "ok")
+		case "REST":
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			reply(350, "restarting")
+		case "RETR":
+			if statErr != nil || info.IsDir() {
+				reply(550, "no such file")
+				continue
+			}
+			data := openData()
+			if data == nil {
+				continue
+			}
+			content := s.files[name].Data
+			data.Write(content[rest:])
+			data.Close()
+			rest = 0
+			reply(226, "transfer complete")
+		case "MLSD", "LIST":
+			if command == "MLSD" && s.noMLSD {
+				reply(500, "unknown command")
+				continue
+			}
+			entries, err := fs.ReadDir(s.files, name)
+			if err != nil {
+				reply(550, "no such directory")
+				continue
+			}
+			data := openData()
+			if data == nil {
+				continue
+			}
+			for _, entry := range entries {
+				info, _ := entry.Info()
+				if command == "MLSD" {
+					kind := "file"
+					if entry.IsDir() {
+						kind = "dir"
+					}
+					fmt.Fprintf(data, "type=%s;size=%d;modify=%s; %s\r\n", kind, info.Size(), info.ModTime().UTC().Format("20060102150405"), entry.Name())
+				} else {
+					mode := "-rw-r--r--"
+					if entry.IsDir() {
+						mode = "drwxr-xr-x"
+					}
+					fmt.Fprintf(data, "%s   1 ftp      ftp      %8d %s %s\r\n", mode, info.Size(), info.ModTime().UTC().Format("Jan _2  2006"), entry.Name())
+				}
+			}
+			data.Close()
+			reply(226, "listing sent")
+		default:
+			reply(502, "not implemented")
+		}
+	}
+}
+
+// ftpFiles is the content served in the tests
+func ftpFiles() fstest.MapFS {
+	modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	return fstest.MapFS{
+		"pub/readme.txt":            {Data: []byte("legacy data lives here\n"), ModTime: modTime},
+		"pub/data/archive 2020.csv": {Data: []byte(strings.Repeat("a,b,c\n", 1000)), ModTime: modTime},
+		"pub/data/old/notes.txt":    {Data: []byte("notes"), ModTime: modTime},
+	}
+}
+
+func TestDownloadFTP(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", &ca)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{serverCert.tlsCertificate()}}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientTLS := &tls.Config{RootCAs: roots}
+
+	tests := []struct {
+		name     string
+		server   *ftpServer
+		scheme   string
+		userinfo string
+		config   HTTPConfig
+		file     string
+		wantErr  bool
+		wantSent []string // commands the server must have received
+	}{
+		{name: "Anonymous passive", server: &ftpServer{}, file: "pub/readme.txt", wantSent: []string{"USER anonymous", "EPSV", "RETR pub/readme.txt"}},
+		{name: "PASV when EPSV is missing", server: &ftpServer{noEPSV: true}, file: "pub/readme.txt", wantSent: []string{"PASV"}},
+		{name: "Active mode", server: &ftpServer{}, config: HTTPConfig{FTPActive: true}, file: "pub/readme.txt", wantSent: []string{"EPRT |1|127.0.0.1|"}},
+		{name: "Credentials in the URL", server: &ftpServer{user: "bob", password: "s3cret"}, userinfo: "bob:s3cret@", file: "pub/readme.txt", wantSent: []string{"USER bob"}},
+		{name: "Credentials from --user", server: &ftpServer{user: "bob", password: "s3cret"}, config: HTTPConfig{User: "bob", Password: "s3cret"}, file: "pub/readme.txt"},
+		{name: "Wrong password", server: &ftpServer{user: "bob", password: "s3cret"}, userinfo: "bob:guess@", file: "pub/readme.txt", wantErr: true},
+		{name: "Missing file", server: &ftpServer{}, file: "pub/missing.txt", wantErr: true},
+		{name: "Explicit FTPS", server: &ftpServer{tls: serverTLS}, scheme: "ftps", config: HTTPConfig{TLS: clientTLS}, file: "pub/data/archive%202020.csv", wantSent: []string{"AUTH TLS", "PROT P"}},
+		{name: "Implicit FTPS", server: &ftpServer{tls: serverTLS, implicit: true}, scheme: "ftps", config: HTTPConfig{TLS: clientTLS, FTPSImplicit: true}, file: "pub/readme.txt", wantSent: []string{"PROT P"}},
+		{name: "Explicit FTPS refused", server: &ftpServer{}, scheme: "ftps", config: HTTPConfig{TLS: clientTLS}, file: "pub/readme.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.files = ftpFiles()
+			scheme := tt.scheme
+			if scheme == "" {
+				scheme = "ftp"
+			}
+			rawURL := scheme + "://" + tt.userinfo + tt.server.start(t) + "/" + tt.file
+
+			out := t.TempDir()
+			var log bytes.Buffer
+			opts := Options{Directory: out, Tries: 2, WaitRetry: time.Millisecond, Log: &log, HTTP: NewHTTPClient(tt.config)}
+			result, err := Download(context.Background(), Request{URL: rawURL}, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v\n%s", err, tt.wantErr, log.String())
+			}
+			if err != nil && IsRetryable(err) {
+				t.Errorf("Download() error %v should not be retried", err)
+			}
+			for _, command := range tt.wantSent {
+				if !tt.server.sent(command) {
+					t.Errorf("server did not receive %q: %q", command, tt.server.commands)
+				}
+			}
+			if err != nil {
+				return
+			}
+			want := tt.server.files[strings.ReplaceAll(tt.file, "%20", " ")].Data
+			if data, _ := os.ReadFile(result.Path); !bytes.Equal(data, want) {
+				t.Errorf("saved %d bytes to %s, want %d", len(data), result.Path, len(want))
+			}
+			if strings.Contains(log.String(), "s3cret") {
+				t.Errorf("log shows the password:\n%s", log.String())
+			}
+		})
+	}
+}
+
+func TestFTPResumeAndTimestamp(t *testing.T) {
+	server := &ftpServer{files: ftpFiles()}
+	base := "ftp://" + server.start(t) + "/pub/"
+	content := server.files["pub/readme.txt"].Data
+
+	t.Run("Resume with REST", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(PartPath(filepath.Join(dir, "readme.txt")), content[:7], 0o644)
+		opts := Options{Directory: dir, Tries: 1, Continue: true, Log: &bytes.Buffer{}}
+		if _, err := Download(context.Background(), Request{URL: base + "readme.txt"}, opts); err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "readme.txt")); !bytes.Equal(data, content) {
+			t.Errorf("resumed file = %q, want %q", data, content)
+		}
+		if !server.sent("REST 7") {
+			t.Errorf("server did not receive REST 7: %q", server.commands)
+		}
+	})
+
+	t.Run("Newer local copy kept", func(t *testing.T) {
+		dir := t.TempDir()
+		local := filepath.Join(dir, "readme.txt")
+		os.WriteFile(local, content, 0o644)
+		opts := Options{Directory: dir, Tries: 1, Timestamping: true, Log: &bytes.Buffer{}}
+		result, err := Download(context.Background(), Request{URL: base + "readme.txt"}, opts)
+		if err != nil || !result.UpToDate {
+			t.Errorf("Download() = %+v, %v; want the local copy kept", result, err)
+		}
+	})
+}
+
+func TestFTPDirectories(t *testing.T) {
+	for _, noMLSD := range []bool{false, true} {
+		t.Run(fmt.Sprintf("LIST only %v", noMLSD), func(t *testing.T) {
+			server := &ftpServer{files: ftpFiles(), noMLSD: noMLSD}
+			addr := server.start(t)
+
+			// A directory named without its slash is redirected to its index
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 1, Log: &bytes.Buffer{}}
+			if _, err := Download(context.Background(), Request{URL: "ftp://" + addr + "/pub"}, opts); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			index, _ := os.ReadFile(filepath.Join(dir, "index.html"))
+			for _, link := range []string{`href="data/"`, `href="readme.txt"`} {
+				if !strings.Contains(string(index), link) {
+					t.Errorf("index.html lacks %s:\n%s", link, index)
+				}
+			}
+
+			if err := DownloadTree(context.Background(), "ftp://"+addr+"/pub/", opts); err != nil {
+				t.Fatalf("DownloadTree() error = %v", err)
+			}
+			host, _, _ := net.SplitHostPort(addr)
+			for name, file := range server.files {
+				if data, _ := os.ReadFile(filepath.Join(dir, host, filepath.FromSlash(name))); !bytes.Equal(data, file.Data) {
+					t.Errorf("%s was not downloaded", name)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLIST(t *testing.T) {
+	tests := []struct {
+		line string
+		want dirEntry
+		ok   bool
+	}{
+		{line: "-rw-r--r--   1 ftp  ftp   1024 Jan 02  2020 report final.pdf", want: dirEntry{Name: "report final.pdf", Size: 1024, ModTime: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, ok: true},
+		{line: "drwxr-xr-x   2 ftp  ftp   4096 Mar 10  2019 archive", want: dirEntry{Name: "archive", Dir: true, Size: 4096, ModTime: time.Date(2019, 3, 10, 0, 0, 0, 0, time.UTC)}, ok: true},
+		{line: "lrwxrwxrwx   1 ftp  ftp     11 Mar 10  2019 latest -> archive/v2", want: dirEntry{Name: "latest", Size: 11, ModTime: time.Date(2019, 3, 10, 0, 0, 0, 0, time.UTC)}, ok: true},
+		{line: "total 12"},
+	}
+	for _, tt := range tests {
+		got, ok := parseLIST(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseLIST(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFTPCredentialsStayHome(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	os.WriteFile(netrc, []byte("default login dflt password n3trc\n"), 0o600)
+	config := HTTPConfig{User: "alice", Password: "s3cret", Netrc: netrc}
+
+	tests := []struct {
+		name     string
+		redirect bool // reach the FTP server through a redirect from another origin
+		wantUser string
+	}{
+		{name: "Origin gets --user", wantUser: "USER alice"},
+		{name: "Redirect target stays anonymous", redirect: true, wantUser: "USER anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &ftpServer{files: ftpFiles()}
+			if !tt.redirect {
+				server.user, server.password = "alice", "s3cret"
+			}
+			ftpURL := "ftp://" + server.start(t) + "/pub/readme.txt"
+			rawURL := ftpURL
+			if tt.redirect {
+				web := httptest.NewServer(http.RedirectHandler(ftpURL, http.StatusFound))
+				defer web.Close()
+				rawURL = web.URL + "/readme.txt"
+			}
+			opts := Options{Directory: t.TempDir(), Tries: 1, HTTP: NewHTTPClient(config)}
+			if _, err := Download(context.Background(), Request{URL: rawURL}, opts); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if !server.sent(tt.wantUser) || server.sent("PASS s3cret") != !tt.redirect || server.sent("USER dflt") {
+				t.Errorf("server received %q, want %q", server.commands, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wiget/internal/rateLimiter"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// listEntry is one download of a JSON or YAML manifest
+type listEntry struct {
+	URL       string            `json:"url" yaml:"url"`
+	Out       string            `json:"out" yaml:"out"`
+	Dir       string            `json:"dir" yaml:"dir"`
+	Checksum  string            `json:"checksum" yaml:"checksum"`
+	Headers   map[string]string `json:"headers" yaml:"headers"`
+	RateLimit string            `json:"rate-limit" yaml:"rate-limit"`
+	Mirrors   []string          `json:"mirrors" yaml:"mirrors"`
+}
+
+// inputList is an -i list being read
+type inputList struct {
+	io.ReadCloser
+	html   bool   // an HTML page whose links are the downloads
+	base   string // URL the relative links of an HTML page are resolved against
+	remote bool   // fetched from a server, which may not pick local files or where files go
+}
+
+// openList opens the -i list at source: "-" for the standard input, a URL
+// or a local path. A list served as text/html is read as a page of links,
+// relative to where it was served from unless opts.BaseURL says otherwise.
+func openList(ctx context.Context, source string, opts Options) (*inputList, error) {
+	list := &inputList{html: opts.ForceHTML, base: opts.BaseURL}
+	if source == "-" {
+		list.ReadCloser = io.NopCloser(os.Stdin)
+		return list, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || len(u.Scheme) < 2 {
+		if list.ReadCloser, err = os.Open(source); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	stream, err := Open(ctx, opts, source, ByteRange{})
+	if err != nil {
+		return nil, err
+	}
+	list.ReadCloser = stream.Body
+	list.remote = !localSchemes[strings.ToLower(u.Scheme)]
+	if mediaType, _, _ := mime.ParseMediaType(stream.ContentType); mediaType == "text/html" {
+		list.html = true
+	}
+	if list.base == "" {
+		list.base = stream.URL
+	}
+	return list, nil
+}
+
+// scan calls yield with each request of the list. Requests that a remote
+// list may not make are reported and left out.
+func (l *inputList) scan(yield func(Request) bool) error {
+	checked := func(req Request) bool {
+		if l.remote {
+			if err := checkRemoteRequest(req); err != nil {
+				fmt.Printf("Skipping %s: %v\n", RedactURL(req.URL), err)
+				return true
+			}
+		}
+		return yield(req)
+	}
+	if l.html {
+		return scanHTML(l, l.base, checked)
+	}
+	return scanList(l, checked)
+}
+
+// checkRemoteRequest refuses a request of a list fetched from a server that
+// reads a local file or saves outside the download directory
+func checkRemoteRequest(req Request) error {
+	for _, source := range append([]string{req.URL}, req.Mirrors...) {
+		if u, err := url.Parse(source); err != nil || strings.EqualFold(u.Scheme, "file") {
+			return errors.New("a list fetched from a URL cannot name local files")
+		}
+	}
+	if strings.HasPrefix(req.Directory, "~") || !filepath.IsLocal(filepath.Join(req.Directory, req.Output, "file")) {
+		return errors.New("a list fetched from a URL cannot save files outside the download directory")
+	}
+	return nil
+}
+
+// scanHTML calls yield with each link of the HTML page in r, once, resolved
+// against base or the <base> of the page. Links to nothing that can be
+// downloaded, such as mailto: or data: URLs, are left out.
+func scanHTML(r io.Reader, base string, yield func(Request) bool) error {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", base, err)
+	}
+	seen := map[string]bool{}
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return err
+			}
+			return nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			for _, attr := range token.Attr {
+				if attr.Key != "href" && attr.Key != "src" {
+					continue
+				}
+				link, err := url.Parse(strings.TrimSpace(attr.Val))
+				if err != nil || attr.Val == "" {
+					continue
+				}
+				link.Fragment = ""
+				if !link.IsAbs() {
+					if !baseURL.IsAbs() {
+						fmt.Printf("Skipping relative link %s, use --base to resolve it\n", attr.Val)
+						continue
+					}
+					link = baseURL.ResolveReference(link)
+				}
+				if token.Data == "base" {
+					baseURL = link
+					continue
+				}
+				scheme := strings.ToLower(link.Scheme)
+				if scheme == "data" || !fetchable(scheme) || seen[link.String()] {
+					continue
+				}
+				seen[link.String()] = true
+				if !yield(Request{URL: link.String()}) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// scanList calls yield with each request listed in r, in order, until yield
+// returns false. A list of downloads (-i) is either text or a JSON or YAML
+// manifest, told apart by its first line that is not blank or a # comment.
+//
+// A text list has one URL or local path per line. Other URLs on the line are
+// mirrors of the same file, a key=value word is an option and anything else
+// is the checksum, either "algorithm:hex" or a bare hex digest. Lines indented under a URL hold more options for it, one
+// per line, as aria2 input files do:
+//
+//	# nightly images
+//	https://example.com/a.iso sha256:9f86d081884c7d65...
+//	https://example.com/b.iso https://mirror.example.org/b.iso
+//	  out=b-nightly.iso
+//	  dir=images
+//	  header=Authorization: Bearer abc123
+//	  rate-limit=500k
+//
+// A manifest is an array of objects with the same options:
+//
+//	[{"url": "https://example.com/b.iso", "out": "b-nightly.iso",
+//	  "headers": {"Authorization": "Bearer abc123"}, "mirrors": ["..."]}]
+//
+// or the same as a YAML sequence. Text and JSON lists are read as the
+// downloads go; a YAML manifest is read whole.
+func scanList(r io.Reader, yield func(Request) bool) error {
+	buffered := bufio.NewReader(r)
+	switch listFormat(buffered) {
+	case "json":
+		return scanJSON(buffered, yield)
+	case "yaml":
+		return scanYAML(buffered, yield)
+	}
+	return scanText(buffered, yield)
+}
+
+// listFormat tells a JSON or YAML manifest from a text list by its first
+// significant line, without consuming anything
+func listFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(4096)
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0 || line[0] == '#':
+			continue
+		case line[0] == '[' || line[0] == '{':
+			return "json"
+		case bytes.HasPrefix(line, []byte("---")) || bytes.HasPrefix(line, []byte("- ")):
+			return "yaml"
+		default:
+			return "text"
+		}
+	}
+	return "text"
+}
+
+func scanText(r io.Reader, yield func(Request) bool) error {
+	scanner := bufio.NewScanner(r)
+	var pending *Request
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue // Skip empty lines and comments
+		}
+
+		// An indented line holds an option of the URL above it
+		if text[0] == ' ' || text[0] == '\t' {
+			if pending == nil {
+				return fmt.Errorf("line %d: option %q has no URL above it", line, trimmed)
+			}
+			if err := setListOption(pending, trimmed); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			continue
+		}
+
+		if pending != nil && !yield(*pending) {
+			return nil
+		}
+		fields := strings.Fields(trimmed)
+		pending = &Request{URL: SourceURL(fields[0])}
+		for _, field := range fields[1:] {
+			key, _, _ := strings.Cut(field, "=")
+			switch {
+			case strings.Contains(field, "://"):
+				pending.Mirrors = append(pending.Mirrors, SourceURL(field))
+			case listOptions[key]:
+				if err := setListOption(pending, field); err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+			default:
+				checksum, err := listChecksum(field)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				pending.Checksum = checksum
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending != nil {
+		yield(*pending)
+	}
+	return nil
+}
+
+// listOptions are the options a text list may give a URL
+var listOptions = map[string]bool{
+	"out": true, "dir": true, "checksum": true, "header": true,
+	"rate-limit": true, "max-download-limit": true, "mirror": true,
+}
+
+// setListOption applies the "key=value" option of a text list to req
+func setListOption(req *Request, option string) error {
+	key, value, found := strings.Cut(option, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !found || !listOptions[key] {
+		return fmt.Errorf("unknown option %q", option)
+	}
+	switch key {
+	case "out":
+		req.Output = value
+	case "dir":
+		req.Directory = value
+	case "checksum":
+		req.Checksum = value
+	case "header":
+		name, headerValue, found := strings.Cut(value, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid header %q", value)
+		}
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+	case "rate-limit", "max-download-limit":
+		if _, err := rateLimiter.ParseRateLimit(value); err != nil {
+			return err
+		}
+		req.RateLimit = value
+	case "mirror":
+		req.Mirrors = append(req.Mirrors, SourceURL(value))
+	}
+	return nil
+}
+
+// listChecksum checks a word of a text list that is neither a mirror nor an
+// option, which must be a checksum. A bare hex digest is given the algorithm
+// its length belongs to, so that a typo in an option is not taken for one.
+func listChecksum(word string) (string, error) {
+	if _, err := ParseChecksum(word); err == nil {
+		return word, nil
+	}
+	if _, err := hex.DecodeString(word); err == nil {
+		for algorithm, size := range digestSizes {
+			if len(word) == size {
+				return algorithm + ":" + word, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unknown option %q", word)
+}
+
+// scanJSON reads a JSON array of entries one at a time
+func scanJSON(r io.Reader, yield func(Request) bool) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return errors.New("a JSON list must be an array of downloads")
+	}
+	for n := 1; decoder.More(); n++ {
+		var entry listEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("download %d: %w", n, err)
+		}
+		req, err := entry.request()
+		if err != nil {
+			return fmt.Errorf("download %d: %w", n, err)
+		}
+		if !yield(req) {
+			return nil
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+// scanYAML reads a YAML sequence of entries
+func scanYAML(r io.Reader, yield func(Request) bool) error {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	var entries []listEntry
+	if err := decoder.Decode(&entries); err != nil {
+		return fmt.Errorf("a YAML list must be a sequence of downloads: %w", err)
+	}
+	for n, entry := range entries {
+		req, err := entry.request()
+		if err != nil {
+			return fmt.Errorf("download %d: %w", n+1, err)
+		}
+		if !yield(req) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// request turns e into the Request it describes
+func (e listEntry) request() (Request, error) {
+	if e.URL == "" {
+		return Request{}, errors.New("no url")
+	}
+	if e.RateLimit != "" {
+		if _, err := rateLimiter.ParseRateLimit(e.RateLimit); err != nil {
+			return Request{}, err
+		}
+	}
+	req := Request{
+		URL:       SourceURL(e.URL),
+		Output:    e.Out,
+		Checksum:  e.Checksum,
+		Directory: e.Dir,
+		RateLimit: e.RateLimit,
+	}
+	for _, mirror := range e.Mirrors {
+		req.Mirrors = append(req.Mirrors, SourceURL(mirror))
+	}
+	for name, value := range e.Headers {
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
@@ -0,0 +1,401 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_scanList(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []Request
+		wantErr bool
+	}{
+		{
+			name: "Plain URLs and comments",
+			list: "# images\nhttps://example.com/a.iso\n\nhttps://example.com/b.iso sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n",
+			want: []Request{
+				{URL: "https://example.com/a.iso"},
+				{URL: "https://example.com/b.iso", Checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+			},
+		},
+		{
+			name: "Inline and indented options",
+			list: "https://example.com/b.iso https://mirror.example.org/b.iso out=b.iso\n" +
+				"  dir=images\n\theader=Authorization: Bearer abc\n  # not an option\n  rate-limit=500k\n" +
+				"https://example.com/c.iso\n  mirror=https://mirror.example.org/c.iso\n",
+			want: []Request{
+				{
+					URL: "https://example.com/b.iso", Output: "b.iso", Directory: "images", RateLimit: "500k",
+					Header:  http.Header{"Authorization": {"Bearer abc"}},
+					Mirrors: []string{"https://mirror.example.org/b.iso"},
+				},
+				{URL: "https://example.com/c.iso", Mirrors: []string{"https://mirror.example.org/c.iso"}},
+			},
+		},
+		{
+			name: "Bare digest and a local mirror",
+			list: "https://example.com/a.iso e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 mirror=/srv/a.iso\n",
+			want: []Request{{URL: "https://example.com/a.iso", Checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Mirrors: []string{"file:///srv/a.iso"}}},
+		},
+		{
+			name:    "Unknown option",
+			list:    "https://example.com/a.iso\n  speed=fast\n",
+			wantErr: true,
+		},
+		{
+			name:    "Misspelt inline option",
+			list:    "https://example.com/a.iso ouut=a.iso\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid rate limit",
+			list:    "https://example.com/a.iso\n  rate-limit=fast\n",
+			wantErr: true,
+		},
+		{
+			name: "Rate limit with a lowercase unit",
+			list: "https://example.com/a.iso rate-limit=2m\n",
+			want: []Request{{URL: "https://example.com/a.iso", RateLimit: "2m"}},
+		},
+		{
+			name:    "Option without a URL",
+			list:    "  out=a.iso\nhttps://example.com/a.iso\n",
+			wantErr: true,
+		},
+		{
+			name: "JSON manifest",
+			list: `[
+				{"url": "https://example.com/a.iso", "out": "a.iso", "dir": "images", "checksum": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				 "headers": {"x-token": "abc"}, "rate-limit": "1M", "mirrors": ["https://mirror.example.org/a.iso"]},
+				{"url": "https://example.com/b.iso"}
+			]`,
+			want: []Request{
+				{
+					URL: "https://example.com/a.iso", Output: "a.iso", Directory: "images", Checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					Header: http.Header{"X-Token": {"abc"}}, RateLimit: "1M", Mirrors: []string{"https://mirror.example.org/a.iso"},
+				},
+				{URL: "https://example.com/b.iso"},
+			},
+		},
+		{
+			name:    "JSON entry without a URL",
+			list:    `[{"out": "a.iso"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "JSON entry with an invalid rate limit",
+			list:    `[{"url": "https://example.com/a.iso", "rate-limit": "0k"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "JSON with an unknown field",
+			list:    `[{"url": "https://example.com/a.iso", "output": "a.iso"}]`,
+			wantErr: true,
+		},
+		{
+			name: "YAML manifest",
+			list: "# nightly\n- url: https://example.com/a.iso\n  out: a.iso\n  headers:\n    Authorization: Bearer abc\n- url: https://example.com/b.iso\n",
+			want: []Request{
+				{URL: "https://example.com/a.iso", Output: "a.iso", Header: http.Header{"Authorization": {"Bearer abc"}}},
+				{URL: "https://example.com/b.iso"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Request
+			err := scanList(strings.NewReader(tt.list), func(req Request) bool {
+				got = append(got, req)
+				return true
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scanList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadListOptions(t *testing.T) {
+	var mu sync.Mutex
+	tokens := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens[r.URL.Path] = r.Header.Get("X-Token")
+		mu.Unlock()
+		if r.URL.Path == "/missing.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	list := server.URL + "/a.txt\n  out=first.txt\n  dir=sub\n  header=X-Token: secret\n" +
+		server.URL + "/missing.txt " + server.URL + "/b.txt\n" +
+		server.URL + "/c.txt\n"
+	dir := t.TempDir()
+	opts := Options{Directory: dir, Tries: 1}
+	var err error
+	captureOutput(func() {
+		err = downloadList(context.Background(), &inputList{ReadCloser: io.NopCloser(strings.NewReader(list))}, "", opts)
+	})
+	if err != nil {
+		t.Fatalf("downloadList() error = %v", err)
+	}
+
+	files := map[string]string{
+		"sub/first.txt": "content of /a.txt",
+		"missing.txt":   "content of /b.txt", // saved from the mirror under the name of the URL
+		"c.txt":         "content of /c.txt",
+	}
+	for name, want := range files {
+		if data, _ := os.ReadFile(filepath.Join(dir, name)); string(data) != want {
+			t.Errorf("%s holds %q, want %q", name, data, want)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if tokens["/a.txt"] != "secret" || tokens["/c.txt"] != "" {
+		t.Errorf("X-Token headers = %v, want it sent for a.txt only", tokens)
+	}
+}
+
+func TestDownloadListOutputFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		list    string
+		want    map[string]string // files saved in the download directory
+		wantErr bool
+	}{
+		{
+			name: "One entry saved as -O",
+			list: server.URL + "/a.txt\n" + server.URL + "/b.txt out=b-copy.txt\n",
+			want: map[string]string{"out.txt": "content of /a.txt", "b-copy.txt": "content of /b.txt"},
+		},
+		{
+			name:    "Several entries refused",
+			list:    server.URL + "/a.txt\n" + server.URL + "/b.txt\n" + server.URL + "/c.txt\n",
+			want:    map[string]string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 1, Jobs: 4}
+			var err error
+			captureOutput(func() {
+				err = downloadList(context.Background(), &inputList{ReadCloser: io.NopCloser(strings.NewReader(tt.list))}, "out.txt", opts)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := map[string]string{}
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				data, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+				got[entry.Name()] = string(data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("saved %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadListSameOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Written slowly, so that downloads of the same name would overlap
+		for i := 0; i < 4; i++ {
+			w.Write([]byte(r.URL.Path + ";"))
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	list := server.URL + "/one/a.txt\n" + server.URL + "/one/a.txt\n" + server.URL + "/two/a.txt\n"
+	dir := t.TempDir()
+	opts := Options{Directory: dir, Tries: 1, Jobs: 3, Clobber: Numbered}
+	var err error
+	captureOutput(func() {
+		err = downloadList(context.Background(), &inputList{ReadCloser: io.NopCloser(strings.NewReader(list))}, "", opts)
+	})
+	if err != nil {
+		t.Fatalf("downloadList() error = %v", err)
+	}
+
+	var got []string
+	for _, name := range []string{"a.txt", "a.txt.1", "a.txt.2"} {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		got = append(got, string(data))
+	}
+	sort.Strings(got)
+	want := []string{"/one/a.txt;/one/a.txt;/one/a.txt;/one/a.txt;", "/one/a.txt;/one/a.txt;/one/a.txt;/one/a.txt;", "/two/a.txt;/two/a.txt;/two/a.txt;/two/a.txt;"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("saved %q, want %q", got, want)
+	}
+}
+
+func TestDownloadListLogsClobbering(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new content"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old content"), 0o644)
+	opts := Options{Directory: dir, Tries: 1, Clobber: Numbered}
+	var err error
+	output, _ := captureOutput(func() {
+		err = downloadList(context.Background(), &inputList{ReadCloser: io.NopCloser(strings.NewReader(server.URL + "/a.txt\n"))}, "", opts)
+	})
+	if err != nil {
+		t.Fatalf("downloadList() error = %v", err)
+	}
+	if want := "[a.txt] " + filepath.Join(dir, "a.txt") + " exists, saving as " + filepath.Join(dir, "a.txt.1"); !strings.Contains(output, want) {
+		t.Errorf("output %q does not say %q", output, want)
+	}
+}
+
+func Test_scanHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		base string
+		want []string
+	}{
+		{
+			name: "Links resolved against the base",
+			page: `<a href="files/a.zip">a</a><img src="/img/logo.png"><a href="https://other.example/b.zip#top">b</a>`,
+			base: "https://example.com/downloads/",
+			want: []string{"https://example.com/downloads/files/a.zip", "https://example.com/img/logo.png", "https://other.example/b.zip"},
+		},
+		{
+			name: "Base of the page",
+			page: `<base href="https://cdn.example.com/v2/"><a href="a.zip">a</a>`,
+			base: "https://example.com/",
+			want: []string{"https://cdn.example.com/v2/a.zip"},
+		},
+		{
+			name: "Duplicates and links that cannot be downloaded",
+			page: `<a href="a.zip">a</a><a href="a.zip#again">a</a><a href="mailto:me@example.com">mail</a>` +
+				`<a href="javascript:void(0)">js</a><img src="data:image/png;base64,AAAA"><a href="">empty</a>`,
+			base: "https://example.com/",
+			want: []string{"https://example.com/a.zip"},
+		},
+		{
+			name: "Relative links without a base",
+			page: `<a href="a.zip">a</a><a href="ftp://ftp.example.com/b.zip">b</a>`,
+			want: []string{"ftp://ftp.example.com/b.zip"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var err error
+			captureOutput(func() {
+				err = scanHTML(strings.NewReader(tt.page), tt.base, func(req Request) bool {
+					got = append(got, req.URL)
+					return true
+				})
+			})
+			if err != nil {
+				t.Fatalf("scanHTML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanHTML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadListSources(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list.txt":
+			w.Write([]byte(server.URL + "/a.txt\n" +
+				"/etc/hostname\n" +
+				server.URL + "/b.txt out=../escaped.txt\n"))
+		case "/links/index.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<a href="a.txt">a</a> <a href="/b.txt">b</a> <a href="mailto:me@example.com">mail</a>`))
+		default:
+			w.Write([]byte("content of " + r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	page := filepath.Join(t.TempDir(), "links.html")
+	os.WriteFile(page, []byte(`<a href="c.txt">c</a>`), 0o644)
+
+	tests := []struct {
+		name      string
+		source    string
+		stdin     string
+		forceHTML bool
+		baseURL   string
+		want      []string // files saved in the download directory
+	}{
+		{name: "Standard input", source: "-", stdin: server.URL + "/a.txt\n" + server.URL + "/b.txt\n", want: []string{"a.txt", "b.txt"}},
+		{name: "Remote text list", source: server.URL + "/list.txt", want: []string{"a.txt"}},
+		{name: "Remote HTML page", source: server.URL + "/links/index.html", want: []string{"a.txt", "b.txt"}},
+		{name: "Local HTML page with a base", source: page, forceHTML: true, baseURL: server.URL + "/files/", want: []string{"c.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stdin != "" {
+				stdin := filepath.Join(t.TempDir(), "stdin")
+				os.WriteFile(stdin, []byte(tt.stdin), 0o644)
+				file, _ := os.Open(stdin)
+				defer file.Close()
+				orig := os.Stdin
+				os.Stdin = file
+				defer func() { os.Stdin = orig }()
+			}
+			root := t.TempDir()
+			dir := filepath.Join(root, "downloads")
+			opts := Options{Directory: dir, Tries: 1, ForceHTML: tt.forceHTML, BaseURL: tt.baseURL}
+			var err error
+			captureOutput(func() {
+				err = DownloadMultipleFiles(context.Background(), tt.source, "", opts)
+			})
+			if err != nil {
+				t.Fatalf("DownloadMultipleFiles() error = %v", err)
+			}
+			entries, _ := os.ReadDir(dir)
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("saved %v, want %v", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+				t.Errorf("a remote list saved a file outside the download directory")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"os"
+	"strings"
+)
+
+// netrcEntry is one "machine" or "default" entry of a .netrc file
+type netrcEntry struct {
+	machine  string // empty for the default entry
+	login    string
+	password string
+}
+
+// loadNetrc reads the entries of the .netrc file at path. A missing or
+// unreadable file has no entries.
+func loadNetrc(path string) []netrcEntry {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return parseNetrc(string(data))
+}
+
+// parseNetrc parses the tokens of a .netrc file. Macro definitions are
+// skipped, as are tokens this program has no use for such as "account".
+func parseNetrc(data string) []netrcEntry {
+	var entries []netrcEntry
+	var current *netrcEntry
+
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			if strings.HasPrefix(fields[j], "#") {
+				break // the rest of the line is a comment
+			}
+			value := ""
+			if j+1 < len(fields) {
+				value = fields[j+1]
+			}
+			switch fields[j] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: value})
+				current = &entries[len(entries)-1]
+				j++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login", "password", "account":
+				if current != nil && fields[j] == "login" {
+					current.login = value
+				} else if current != nil && fields[j] == "password" {
+					current.password = value
+				}
+				j++
+			case "macdef":
+				// A macro runs until the next empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// lookupNetrc returns the login and password entries hold for host. A
+// "machine" entry for the host wins over the "default" one, which is only
+// used when withDefault is set.
+func lookupNetrc(entries []netrcEntry, host string, withDefault bool) (string, string, bool) {
+	var fallback *netrcEntry
+	for i, entry := range entries {
+		if entry.machine == "" {
+			if fallback == nil {
+				fallback = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(entry.machine, host) {
+			return entry.login, entry.password, true
+		}
+	}
+	if fallback != nil && withDefault {
+		return fallback.login, fallback.password, true
+	}
+	return "", "", false
+}
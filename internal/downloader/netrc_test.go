@@ -0,0 +1,41 @@
+package downloader
+
+import "testing"
+
+func TestLookupNetrc(t *testing.T) {
+	entries := parseNetrc(`# credentials for the artifact servers
+machine artifacts.example.com login builder password s3cret
+machine files.example.com
+	login alice
+	password open
+	account ignored
+
+macdef init
+machine evil.example.com login macro password macro
+
+default login anonymous password guest@
+`)
+
+	tests := []struct {
+		name         string
+		host         string
+		withDefault  bool
+		wantLogin    string
+		wantPassword string
+		wantOK       bool
+	}{
+		{name: "One line entry", host: "artifacts.example.com", wantLogin: "builder", wantPassword: "s3cret", wantOK: true},
+		{name: "Entry over several lines", host: "FILES.example.com", wantLogin: "alice", wantPassword: "open", wantOK: true},
+		{name: "Macro bodies are skipped", host: "evil.example.com", withDefault: false},
+		{name: "Default entry", host: "other.example.com", withDefault: true, wantLogin: "anonymous", wantPassword: "guest@", wantOK: true},
+		{name: "Default entry not wanted", host: "other.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, password, ok := lookupNetrc(entries, tt.host, tt.withDefault)
+			if login != tt.wantLogin || password != tt.wantPassword || ok != tt.wantOK {
+				t.Errorf("lookupNetrc() = %q, %q, %v, want %q, %q, %v", login, password, ok, tt.wantLogin, tt.wantPassword, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Options holds the settings shared by every download path
+type Options struct {
+	RateLimit string // throttle speed, e.g. "400k" or "2M"
+	Directory string // directory the files are saved to (-P)
+	Continue  bool   // resume partially downloaded files (-c / --continue)
+	Split     int    // parallel connections for a single large file (--split)
+
+	Checksum   string // expected digest such as "sha256:<hex>", or ChecksumAuto
+	Quarantine bool   // keep files that fail verification as <name>.quarantine
+
+	RemovePartial bool // delete unfinished files when a download is interrupted
+
+	Timestamping bool // only fetch files newer than the local copy (-N)
+
+	Clobber ClobberPolicy // what to do when the output file already exists
+	Backups int           // with Overwrite, how many old copies to keep (--backups)
+
+	Tries     int           // attempts per file before giving up (--tries)
+	WaitRetry time.Duration // longest pause between two attempts (--waitretry)
+
+	Jobs       int    // downloads of an -i list running at once, DefaultJobs when 0 (--jobs)
+	MaxPerHost int    // downloads of an -i list from one host at once, 0 for no limit (--max-per-host)
+	ForceHTML  bool   // read the -i list as an HTML page and download its links (--force-html)
+	BaseURL    string // URL the relative links of an HTML list are resolved against (--base)
+
+	AdjustExtension bool // add .html to the name of HTML files saved without it (--mirror)
+	StayOnSite      bool // skip a file redirected to another site, see SameSite (--mirror)
+
+	HTTP   *HTTPClient // sends the requests, nil uses the default headers and timeouts
+	Header http.Header // headers added to those of HTTP for these downloads
+
+	Log      io.Writer      // receives the messages of a download, nil discards them
+	Progress func(Progress) // called as data arrives, nil draws nothing
+	Report   *Report        // records the outcome of every download, nil keeps none
+}
+
+// log returns where messages should be written
+func (o Options) log() io.Writer {
+	if o.Log == nil {
+		return io.Discard
+	}
+	return o.Log
+}
+
+// Request names one file to download
+type Request struct {
+	URL      string
+	Output   string // file name, derived from the URL when empty
+	Checksum string // expected digest, overrides Options.Checksum
+
+	Directory string      // where to save the file, under Options.Directory unless absolute
+	Header    http.Header // extra headers sent for this file only
+	RateLimit string      // overrides Options.RateLimit
+	Mirrors   []string    // other URLs of the same file, tried in turn when URL fails
+}
+
+// options returns opts with the settings of r applied
+func (r Request) options(opts Options) Options {
+	if r.Directory != "" {
+		if filepath.IsAbs(r.Directory) || strings.HasPrefix(r.Directory, "~") {
+			opts.Directory = r.Directory
+		} else {
+			opts.Directory = filepath.Join(opts.Directory, r.Directory)
+		}
+	}
+	if r.RateLimit != "" {
+		opts.RateLimit = r.RateLimit
+	}
+	if len(r.Header) > 0 {
+		header := opts.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		for key, values := range r.Header {
+			header[key] = values
+		}
+		opts.Header = header
+	}
+	return opts
+}
+
+// Result describes a finished download
+type Result struct {
+	URL        string
+	FinalURL   string        // where the file was served from after redirects
+	Path       string        // where the file was saved
+	Bytes      int64         // bytes received during this run
+	Size       int64         // size of the complete file
+	Duration   time.Duration // time spent downloading, retries included
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Checksum   string // "algorithm:digest" of the saved file, when it was hashed
+	Resumed    bool   // the download continued a partial file
+	UpToDate   bool   // the file was already complete and nothing was fetched
+	Skipped    bool   // the file already existed and Options.Clobber kept it
+}
+
+// Progress is reported to Options.Progress while a file downloads
+type Progress struct {
+	Done       int64     // bytes of the file saved so far
+	Total      int64     // expected size of the file, -1 when the server did not say
+	Downloaded int64     // bytes received since Started
+	Started    time.Time // when the transfer began
+}
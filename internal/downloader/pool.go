@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultJobs is how many downloads of a list run at once unless
+// Options.Jobs says otherwise
+const DefaultJobs = 5
+
+// queueWindow is how many requests of a list are read ahead of the running
+// downloads. It bounds the memory a huge list takes, and is how far the
+// scheduler looks for a request of another host when one host is busy.
+const queueWindow = 1024
+
+// hostQueue holds the waiting requests of one host, oldest first
+type hostQueue struct {
+	host     string
+	requests []Request
+}
+
+// scheduler hands the requests of a list to the workers: first in, first
+// out for each host, and in turn across hosts, so that a long run of one
+// host in the list does not hold up the others. No host gets more than
+// maxPerHost workers at once.
+type scheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	hosts      []*hostQueue // hosts with waiting requests, in turn order
+	next       int          // index in hosts of the next host to serve
+	active     map[string]int
+	queued     int
+	maxQueued  int
+	maxPerHost int // 0 for no limit
+	closed     bool
+	stopped    bool
+	stop       func() bool // stops watching the context
+}
+
+func newScheduler(ctx context.Context, maxQueued, maxPerHost int) *scheduler {
+	s := &scheduler{active: map[string]int{}, maxQueued: maxQueued, maxPerHost: maxPerHost}
+	s.cond = sync.NewCond(&s.mu)
+	s.stop = context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	return s
+}
+
+// push queues req, waiting while the queue is full. It returns false once
+// the scheduler is stopped.
+func (s *scheduler) push(req Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.queued >= s.maxQueued && !s.stopped {
+		s.cond.Wait()
+	}
+	if s.stopped {
+		return false
+	}
+	host := requestHost(req.URL)
+	var queue *hostQueue
+	for _, q := range s.hosts {
+		if q.host == host {
+			queue = q
+			break
+		}
+	}
+	if queue == nil {
+		queue = &hostQueue{host: host}
+		s.hosts = append(s.hosts, queue)
+	}
+	queue.requests = append(queue.requests, req)
+	s.queued++
+	s.cond.Broadcast()
+	return true
+}
+
+// close tells the workers that no more requests are coming
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// pop waits for a request whose host has a worker to spare and takes it. It
+// returns false when the list is done or the scheduler is stopped.
+func (s *scheduler) pop() (Request, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.stopped || (s.closed && s.queued == 0) {
+			return Request{}, false
+		}
+		for i := 0; i < len(s.hosts); i++ {
+			n := (s.next + i) % len(s.hosts)
+			queue := s.hosts[n]
+			if s.maxPerHost > 0 && s.active[queue.host] >= s.maxPerHost {
+				continue
+			}
+			req := queue.requests[0]
+			queue.requests = queue.requests[1:]
+			s.active[queue.host]++
+			s.queued--
+			s.next = n + 1
+			if len(queue.requests) == 0 {
+				s.hosts = append(s.hosts[:n], s.hosts[n+1:]...)
+				s.next = n
+			}
+			if len(s.hosts) > 0 {
+				s.next %= len(s.hosts)
+			} else {
+				s.next = 0
+			}
+			s.cond.Broadcast()
+			return req, true
+		}
+		s.cond.Wait()
+	}
+}
+
+// done gives back the worker of a request taken with pop
+func (s *scheduler) done(req Request) {
+	s.mu.Lock()
+	host := requestHost(req.URL)
+	if s.active[host]--; s.active[host] <= 0 {
+		delete(s.active, host)
+	}
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// requestHost is the host whose limit a request counts against
+func requestHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerTakesHostsInTurn(t *testing.T) {
+	queue := newScheduler(context.Background(), 10, 0)
+	defer queue.stop()
+	for _, name := range []string{"a/1", "a/2", "a/3", "b/1", "c/1", "b/2"} {
+		host, file, _ := strings.Cut(name, "/")
+		queue.push(Request{URL: "https://" + host + ".example/" + file})
+	}
+	queue.close()
+
+	var order []string
+	for {
+		req, ok := queue.pop()
+		if !ok {
+			break
+		}
+		queue.done(req)
+		u, _ := url.Parse(req.URL)
+		order = append(order, strings.TrimSuffix(u.Host, ".example")+u.Path)
+	}
+	if got, want := strings.Join(order, " "), "a/1 b/1 c/1 a/2 b/2 a/3"; got != want {
+		t.Errorf("requests taken in order %q, want %q", got, want)
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := newScheduler(ctx, 3, 1)
+	defer queue.stop()
+	for _, u := range []string{"https://a.example/1", "https://a.example/2", "https://b.example/1"} {
+		queue.push(Request{URL: u})
+	}
+
+	// The queue is full, so the list is not read further
+	pushed := make(chan bool)
+	go func() { pushed <- queue.push(Request{URL: "https://c.example/1"}) }()
+	select {
+	case <-pushed:
+		t.Fatal("push() did not wait for room in the queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	first, _ := queue.pop()
+	if !<-pushed {
+		t.Fatal("push() failed after a request was taken")
+	}
+	// a.example has its one worker, so the next hosts go first
+	if second, _ := queue.pop(); second.URL != "https://b.example/1" {
+		t.Errorf("second request = %s, want b.example", second.URL)
+	}
+	if third, _ := queue.pop(); third.URL != "https://c.example/1" {
+		t.Errorf("third request = %s, want c.example", third.URL)
+	}
+	popped := make(chan Request)
+	go func() {
+		req, _ := queue.pop()
+		popped <- req
+	}()
+	select {
+	case req := <-popped:
+		t.Fatalf("pop() returned %s while a.example was busy", req.URL)
+	case <-time.After(20 * time.Millisecond):
+	}
+	queue.done(first)
+	if req := <-popped; req.URL != "https://a.example/2" {
+		t.Errorf("request after a.example was free = %s", req.URL)
+	}
+
+	// Cancelling stops waiting workers
+	go func() {
+		_, ok := queue.pop()
+		pushed <- ok
+	}()
+	cancel()
+	if <-pushed {
+		t.Errorf("pop() returned a request after cancellation")
+	}
+}
+
+func TestDownloadListWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running := map[string]int{}
+	total, maxTotal, maxHost := 0, 0, 0
+	fetcher := FetcherFunc(func(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+		host := requestHost(rawURL)
+		mu.Lock()
+		running[host]++
+		total++
+		maxTotal = max(maxTotal, total)
+		maxHost = max(maxHost, running[host])
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running[host]--
+		total--
+		mu.Unlock()
+		return &Stream{Body: io.NopCloser(strings.NewReader(rawURL))}, nil
+	})
+
+	var list strings.Builder
+	for i := 0; i < 24; i++ {
+		fmt.Fprintf(&list, "https://host%d.example/file%d.txt\n", i%3, i)
+	}
+	dir := t.TempDir()
+	opts := Options{
+		Directory: dir, Tries: 1, Jobs: 4, MaxPerHost: 2,
+		HTTP: NewHTTPClient(HTTPConfig{Fetchers: map[string]Fetcher{"https": fetcher}}),
+	}
+	var err error
+	captureOutput(func() {
+		err = downloadList(context.Background(), &inputList{ReadCloser: io.NopCloser(strings.NewReader(list.String()))}, "", opts)
+	})
+	if err != nil {
+		t.Fatalf("downloadList() error = %v", err)
+	}
+	if maxTotal > 4 || maxHost > 2 {
+		t.Errorf("ran %d downloads at once and %d from one host, want at most 4 and 2", maxTotal, maxHost)
+	}
+	for i := 0; i < 24; i++ {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("file%d.txt", i))); err != nil {
+			t.Errorf("file%d.txt was not downloaded: %v", i, err)
+		}
+	}
+}
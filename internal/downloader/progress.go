@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ProgressBar returns a Progress callback that redraws a progress bar on the
+// current line of w.
+func ProgressBar(w io.Writer) func(Progress) {
+	return func(p Progress) {
+		printProgress(w, p)
+	}
+}
+
+// spinner is drawn instead of a bar when the size of the file is unknown
+var spinner = []string{"|", "/", "-", "\\"}
+
+// printProgress draws the bar for p; the speed estimate is based on the bytes
+// received since the transfer started.
+func printProgress(w io.Writer, p Progress) {
+	if p.Total <= 0 {
+		printStreamProgress(w, p)
+		return
+	}
+
+	// Calculate and display the progress
+	progress := float64(p.Done) / float64(p.Total) * 50
+	speed := float64(p.Downloaded) / time.Since(p.Started).Seconds()
+	timeRemaining := time.Duration(float64(p.Total-p.Done)/speed) * time.Second
+
+	// Update the same line with progress
+	fmt.Fprintf(w, "\r %.2f KiB / %.2f KiB [", float64(p.Done)/1024, float64(p.Total)/1024)
+	for i := 0; i < 50; i++ {
+		if i < int(progress) {
+			fmt.Fprint(w, "=")
+		} else {
+			fmt.Fprint(w, " ")
+		}
+	}
+	fmt.Fprintf(w, "] %.2f%% %.2f KiB/s %s", (float64(p.Done)*100)/float64(p.Total), speed/1024, timeRemaining.String())
+}
+
+// printStreamProgress shows a spinner and a byte counter for a download whose
+// size is unknown
+func printStreamProgress(w io.Writer, p Progress) {
+	elapsed := time.Since(p.Started)
+	speed := float64(p.Downloaded) / elapsed.Seconds()
+	frame := spinner[int(elapsed/(100*time.Millisecond))%len(spinner)]
+	fmt.Fprintf(w, "\r %s %.2f KiB %.2f KiB/s %s ", frame, float64(p.Done)/1024, speed/1024, elapsed.Round(time.Second))
+}
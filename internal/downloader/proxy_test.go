@@ -0,0 +1,267 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestProxySelector(t *testing.T) {
+	t.Setenv("http_proxy", "env-proxy:3128")
+	t.Setenv("https_proxy", "")
+	t.Setenv("HTTPS_PROXY", "socks5h://secure-proxy:1080")
+	t.Setenv("no_proxy", "")
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("all_proxy", "")
+	t.Setenv("ALL_PROXY", "")
+
+	tests := []struct {
+		name    string
+		proxy   string
+		noProxy string
+		url     string
+		want    string
+	}{
+		{name: "http_proxy for http", url: "http://example.com/", want: "http://env-proxy:3128"},
+		{name: "HTTPS_PROXY for https", url: "https://example.com/", want: "socks5://secure-proxy:1080"},
+		{name: "Flag replaces the environment", proxy: "http://flag-proxy:8080", url: "https://example.com/", want: "http://flag-proxy:8080"},
+		{name: "Domain and subdomains bypassed", noProxy: ".example.com", url: "http://files.example.com/", want: ""},
+		{name: "Similar domain not bypassed", noProxy: "example.com", url: "http://badexample.com/", want: "http://env-proxy:3128"},
+		{name: "Network bypassed", noProxy: "10.0.0.0/8", url: "http://10.1.2.3/", want: ""},
+		{name: "Port must match", noProxy: "example.com:8080", url: "http://example.com/", want: "http://env-proxy:3128"},
+		{name: "Everything bypassed", noProxy: "*", url: "https://example.com/", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			got, err := newProxySelector(tt.proxy, tt.noProxy).proxy(&http.Request{URL: u})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+				t.Errorf("proxy(%s) = %v, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, proxy := range []string{"ftp://proxy:21", "http://", "socks4://proxy:1080"} {
+		if _, err := ParseProxyURL(proxy); err == nil {
+			t.Errorf("ParseProxyURL(%q) accepted an invalid proxy", proxy)
+		}
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	content := []byte("proxied content")
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer backend.Close()
+	tlsBackend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer tlsBackend.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsBackend.Certificate())
+
+	// The proxies send every host to one of the backends
+	backendFor := func(hostport string) string {
+		if _, port, _ := net.SplitHostPort(hostport); port == "443" {
+			return tlsBackend.Listener.Addr().String()
+		}
+		return backend.Listener.Addr().String()
+	}
+	var mu sync.Mutex
+	var seen []string // what the proxies were asked for
+	record := func(request string) {
+		mu.Lock()
+		seen = append(seen, request)
+		mu.Unlock()
+	}
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r.Method + " " + r.Host + " " + r.Header.Get("Proxy-Authorization"))
+		if r.Method == http.MethodConnect {
+			tunnel(t, w, backendFor(r.Host))
+			return
+		}
+		w.Write(content)
+	}))
+	defer httpProxy.Close()
+	socksProxy := startSOCKS5(t, "alice", "s3cret", func(hostport string) string {
+		record("SOCKS " + hostport)
+		return backendFor(hostport)
+	})
+
+	proxyURL := "http://bob:pw@" + httpProxy.Listener.Addr().String()
+	tests := []struct {
+		name     string
+		proxy    string
+		noProxy  string
+		url      string
+		wantSeen string
+	}{
+		{name: "HTTP proxy", proxy: proxyURL, url: "http://files.test/file.txt", wantSeen: "GET files.test Basic Ym9iOnB3"},
+		{name: "HTTPS through CONNECT", proxy: proxyURL, url: "https://example.com/file.txt", wantSeen: "CONNECT example.com:443 Basic Ym9iOnB3"},
+		{name: "SOCKS5 with authentication", proxy: "socks5://alice:s3cret@" + socksProxy, url: "http://files.test/file.txt", wantSeen: "SOCKS files.test:80"},
+		{name: "No proxy for the host", proxy: proxyURL, noProxy: "127.0.0.1", url: backend.URL + "/file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			seen = nil
+			mu.Unlock()
+			client := NewHTTPClient(HTTPConfig{Proxy: tt.proxy, NoProxy: tt.noProxy, TLS: &tls.Config{RootCAs: roots}})
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 1, HTTP: client}
+			if _, err := Download(context.Background(), Request{URL: tt.url}, opts); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "file.txt")); string(data) != string(content) {
+				t.Errorf("saved %q, want %q", data, content)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.wantSeen == "" && len(seen) > 0 {
+				t.Errorf("proxy was used: %q", seen)
+			}
+			if tt.wantSeen != "" && (len(seen) != 1 || seen[0] != tt.wantSeen) {
+				t.Errorf("proxy saw %q, want %q", seen, tt.wantSeen)
+			}
+		})
+	}
+}
+
+// tunnel answers a CONNECT request by joining the client to addr
+func tunnel(t *testing.T, w http.ResponseWriter, addr string) {
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Error(err)
+		upstream.Close()
+		return
+	}
+	pipe(conn, buf.Reader, upstream)
+}
+
+// startSOCKS5 runs a minimal SOCKS5 proxy requiring user and password. The
+// address asked for is passed to route, which returns where to connect.
+func startSOCKS5(t *testing.T, user, password string, route func(string) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, password, route)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, user, password string, route func(string) string) {
+	r := bufio.NewReader(conn)
+	fail := func() { conn.Close() }
+
+	// Greeting: version, methods; only username/password (2) is offered back
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil || head[0] != 5 {
+		fail()
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, head[1])); err != nil {
+		fail()
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// RFC 1929 authentication
+	readString := func() string {
+		n, _ := r.ReadByte()
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return string(b)
+	}
+	if v, _ := r.ReadByte(); v != 1 {
+		fail()
+		return
+	}
+	if readString() != user || readString() != password {
+		conn.Write([]byte{1, 1})
+		fail()
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	// CONNECT request
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil || req[1] != 1 {
+		fail()
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		host = readString()
+	default:
+		fail()
+		return
+	}
+	portBytes := make([]byte, 2)
+	io.ReadFull(r, portBytes)
+	port := binary.BigEndian.Uint16(portBytes)
+
+	upstream, err := net.Dial("tcp", route(net.JoinHostPort(host, strconv.Itoa(int(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, r, upstream)
+}
+
+// pipe copies between a client connection, read through r, and upstream
+// until either side closes
+func pipe(conn net.Conn, r io.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, r)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	upstream.Close()
+}
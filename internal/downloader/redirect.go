@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errHTTPSDowngrade refuses a redirect from https or ftps to plain http or ftp
+var errHTTPSDowngrade = errors.New("refusing redirect from TLS to an unencrypted scheme (use --allow-https-downgrade)")
+
+// tlsSchemes are encrypted, and a redirect may not leave them for plainSchemes
+var (
+	tlsSchemes   = map[string]bool{"https": true, "ftps": true}
+	plainSchemes = map[string]bool{"http": true, "ftp": true}
+)
+
+// errLocalRedirect refuses a redirect from another scheme to file: or data:
+var errLocalRedirect = errors.New("refusing redirect to a local scheme")
+
+// errRedirectLimit stops a download redirected more than MaxRedirects times
+var errRedirectLimit = errors.New("redirections exceeded")
+
+// SchemeFamily returns the family of the scheme of rawURL: http and https
+// serve the same site, and so do ftp and ftps. Any other scheme is a family
+// of its own.
+func SchemeFamily(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	switch scheme := strings.ToLower(u.Scheme); scheme {
+	case "http", "https":
+		return "http"
+	case "ftp", "ftps":
+		return "ftp"
+	default:
+		return scheme
+	}
+}
+
+// SameSite reports whether two URLs are on the same host and reached with
+// the same family of schemes
+func SameSite(a, b string) bool {
+	urlA, errA := url.Parse(a)
+	urlB, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(urlA.Hostname(), urlB.Hostname()) && SchemeFamily(a) == SchemeFamily(b)
+}
+
+// LogRedirects writes one line for every redirect that led to resp, oldest
+// first, with its status and where it pointed. A redirect that was not
+// followed, with --no-follow-redirects, is logged as such.
+func LogRedirects(w io.Writer, resp *http.Response) {
+	var hops []*http.Response
+	for r := resp; r != nil && r.Request != nil; r = r.Request.Response {
+		if r != resp {
+			hops = append(hops, r)
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "redirected: %s %s -> %s\n", hops[i].Status, RedactURL(hops[i].Request.URL.String()), RedactURL(redirectTarget(hops[i])))
+	}
+	if location := resp.Header.Get("Location"); location != "" && isRedirect(resp.StatusCode) {
+		fmt.Fprintf(w, "location: %s [not following]\n", RedactURL(redirectTarget(resp)))
+	}
+}
+
+// FinalURL returns the URL resp was actually served from, after redirects
+func FinalURL(resp *http.Response, fallback string) string {
+	if resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return fallback
+	}
+	return resp.Request.URL.String()
+}
+
+// redirectTarget resolves the Location of a redirect response
+func redirectTarget(resp *http.Response) string {
+	location, err := resp.Location()
+	if err != nil {
+		return resp.Header.Get("Location")
+	}
+	return location.String()
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
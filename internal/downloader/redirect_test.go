@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadRedirects(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+		case "/middle":
+			http.Redirect(w, r, "/files/report.txt", http.StatusFound)
+		default:
+			w.Write([]byte("report"))
+		}
+	}))
+	defer plain.Close()
+	ftp := &ftpServer{files: ftpFiles()}
+	ftpURL := "ftp://" + ftp.start(t) + "/pub/readme.txt"
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readme.txt" {
+			http.Redirect(w, r, ftpURL, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, plain.URL+"/files/report.txt", http.StatusFound)
+	}))
+	defer secure.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+
+	tests := []struct {
+		name      string
+		config    HTTPConfig
+		url       string
+		wantErr   bool
+		wantFile  string // file saved on success
+		wantLog   string // line expected in the log
+		wantFinal string
+	}{
+		{
+			name:      "Hops are logged and the final URL names the file",
+			url:       plain.URL + "/start",
+			wantFile:  "report.txt",
+			wantLog:   "redirected: 302 Found " + plain.URL + "/middle -> " + plain.URL + "/files/report.txt",
+			wantFinal: plain.URL + "/files/report.txt",
+		},
+		{
+			name:    "Too many redirects",
+			config:  HTTPConfig{MaxRedirects: 1},
+			url:     plain.URL + "/start",
+			wantErr: true,
+			wantLog: "1 redirections exceeded",
+		},
+		{
+			name:    "Redirects not followed",
+			config:  HTTPConfig{NoFollowRedirects: true},
+			url:     plain.URL + "/start",
+			wantErr: true,
+			wantLog: "location: " + plain.URL + "/middle [not following]",
+		},
+		{
+			name:    "HTTPS downgrade refused",
+			url:     secure.URL + "/report.txt",
+			wantErr: true,
+			wantLog: "refusing redirect from TLS to an unencrypted scheme",
+		},
+		{
+			name:    "HTTPS to FTP refused",
+			url:     secure.URL + "/readme.txt",
+			wantErr: true,
+			wantLog: "refusing redirect from TLS to an unencrypted scheme",
+		},
+		{
+			name:      "HTTPS to FTP allowed",
+			config:    HTTPConfig{AllowHTTPSDowngrade: true},
+			url:       secure.URL + "/readme.txt",
+			wantFinal: ftpURL,
+		},
+		{
+			name:      "HTTPS downgrade allowed",
+			config:    HTTPConfig{AllowHTTPSDowngrade: true},
+			url:       secure.URL + "/report.txt",
+			wantFile:  "report.txt",
+			wantFinal: plain.URL + "/files/report.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TLS = &tls.Config{RootCAs: roots}
+			var log bytes.Buffer
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 3, Log: &log, HTTP: NewHTTPClient(tt.config)}
+			result, err := Download(context.Background(), Request{URL: tt.url}, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && IsRetryable(err) {
+				t.Errorf("a redirect error should not be retried: %v", err)
+			}
+			if !strings.Contains(log.String(), tt.wantLog) {
+				t.Errorf("log lacks %q:\n%s", tt.wantLog, log.String())
+			}
+			if tt.wantFile != "" {
+				if data, _ := os.ReadFile(filepath.Join(dir, tt.wantFile)); string(data) != "report" {
+					t.Errorf("%s holds %q, want the report", tt.wantFile, data)
+				}
+			}
+			if result.FinalURL != tt.wantFinal && tt.wantFinal != "" {
+				t.Errorf("FinalURL = %q, want %q", result.FinalURL, tt.wantFinal)
+			}
+		})
+	}
+}
+
+func TestNoFollowRedirectsStatus(t *testing.T) {
+	server := httptest.NewServer(http.RedirectHandler("/elsewhere", http.StatusFound))
+	defer server.Close()
+
+	opts := Options{Directory: t.TempDir(), Tries: 1, Log: &bytes.Buffer{}, HTTP: NewHTTPClient(HTTPConfig{NoFollowRedirects: true})}
+	_, err := Download(context.Background(), Request{URL: server.URL + "/file.txt"}, opts)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusFound {
+		t.Errorf("Download() error = %v, want the 302 status", err)
+	}
+}
+
+func TestSameSite(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "https://example.com/a", b: "http://EXAMPLE.com/b", want: true},
+		{a: "ftp://example.com/a", b: "ftps://example.com/b", want: true},
+		{a: "https://example.com/a", b: "https://cdn.example.com/a", want: false},
+		{a: "https://example.com/a", b: "ftp://example.com/a", want: false},
+		{a: "http://localhost/a", b: "file:///etc/passwd", want: false},
+	}
+	for _, tt := range tests {
+		if got := SameSite(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameSite(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Report collects the outcome of every download of a run, for the summary
+// printed at the end and the exit status. With an output it also writes a
+// JSON line per download as they finish, and one with the totals on Close
+// (--report). It is safe for concurrent use, and a nil *Report ignores
+// everything.
+type Report struct {
+	mu       sync.Mutex
+	start    time.Time
+	summary  Summary
+	exitCode int
+	encoder  *json.Encoder // nil when there is no report file
+	err      error         // first error writing the report file
+}
+
+// Summary holds the totals of a run
+type Summary struct {
+	Type      string  `json:"type"` // "summary"
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Skipped   int     `json:"skipped"` // already there, up to date or kept by --no-clobber
+	Bytes     int64   `json:"bytes"`
+	Seconds   float64 `json:"seconds"`
+	Speed     float64 `json:"bytes_per_second"`
+	ExitCode  int     `json:"exit_code"`
+}
+
+// ReportEntry is the line of the report file for one download
+type ReportEntry struct {
+	Type     string  `json:"type"` // "download"
+	URL      string  `json:"url"`
+	Path     string  `json:"path,omitempty"`
+	Outcome  string  `json:"outcome"` // "succeeded", "failed" or "skipped"
+	Status   int     `json:"status,omitempty"`
+	Bytes    int64   `json:"bytes"`
+	Size     int64   `json:"size,omitempty"`
+	Seconds  float64 `json:"seconds"`
+	Checksum string  `json:"checksum,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	ExitCode int     `json:"exit_code,omitempty"`
+}
+
+// NewReport starts the report of a run. out receives the report file, nil
+// for none.
+func NewReport(out io.Writer) *Report {
+	r := &Report{start: time.Now()}
+	if out != nil {
+		r.encoder = json.NewEncoder(out)
+	}
+	return r
+}
+
+// Add records the download of url that ended with result and err
+func (r *Report) Add(url string, result Result, err error) {
+	if r == nil {
+		return
+	}
+	entry := ReportEntry{
+		Type:     "download",
+		URL:      RedactURL(url),
+		Path:     result.Path,
+		Outcome:  "succeeded",
+		Status:   result.StatusCode,
+		Bytes:    result.Bytes,
+		Size:     result.Size,
+		Seconds:  result.Duration.Seconds(),
+		Checksum: result.Checksum,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil:
+		entry.Outcome, entry.Error, entry.ExitCode = "failed", err.Error(), ExitCode(err)
+		r.summary.Failed++
+		r.exitCode = WorseExitCode(r.exitCode, entry.ExitCode)
+	case result.Skipped || result.UpToDate:
+		entry.Outcome = "skipped"
+		r.summary.Skipped++
+	default:
+		r.summary.Succeeded++
+	}
+	r.summary.Bytes += result.Bytes
+	r.write(entry)
+}
+
+// Fail records an error that is not the failure of one download, such as a
+// list that cannot be read, so that it counts towards the exit status
+func (r *Report) Fail(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mu.Lock()
+	r.exitCode = WorseExitCode(r.exitCode, ExitCode(err))
+	r.mu.Unlock()
+}
+
+// Summary returns the totals so far
+func (r *Report) Summary() Summary {
+	if r == nil {
+		return Summary{Type: "summary"}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.summary
+	summary.Type = "summary"
+	summary.Seconds = time.Since(r.start).Seconds()
+	if summary.Seconds > 0 {
+		summary.Speed = float64(summary.Bytes) / summary.Seconds
+	}
+	summary.ExitCode = r.exitCode
+	return summary
+}
+
+// ExitCode returns the status the run should exit with: the worst of the
+// failures recorded, as WorseExitCode ranks them
+func (r *Report) ExitCode() int {
+	return r.Summary().ExitCode
+}
+
+// Close writes the totals to the report file and returns the first error
+// writing it
+func (r *Report) Close() error {
+	if r == nil {
+		return nil
+	}
+	summary := r.Summary()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(summary)
+	return r.err
+}
+
+// write adds a line to the report file. r.mu must be held.
+func (r *Report) write(line any) {
+	if r.encoder != nil && r.err == nil {
+		r.err = r.encoder.Encode(line)
+	}
+}
+
+// PrintSummary writes the totals of the run as a table
+func (r *Report) PrintSummary(w io.Writer) {
+	summary := r.Summary()
+	fmt.Fprintln(w, "Summary:")
+	fmt.Fprintf(w, "  %-12s %d\n", "Succeeded", summary.Succeeded)
+	fmt.Fprintf(w, "  %-12s %d\n", "Failed", summary.Failed)
+	fmt.Fprintf(w, "  %-12s %d\n", "Skipped", summary.Skipped)
+	fmt.Fprintf(w, "  %-12s %s\n", "Downloaded", byteSize(summary.Bytes))
+	fmt.Fprintf(w, "  %-12s %s\n", "Duration", time.Duration(summary.Seconds*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(w, "  %-12s %s/s\n", "Avg speed", byteSize(int64(summary.Speed)))
+}
+
+// byteSize writes n bytes in the largest binary unit that keeps it above 1
+func byteSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		size /= 1024
+		if size < 1024 || unit == "TiB" {
+			return fmt.Sprintf("%.2f %s", size, unit)
+		}
+	}
+	return ""
+}
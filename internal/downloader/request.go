@@ -1,31 +1,36 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 )
 
-func HttpRequest(url string) (*http.Response, error) {
-	// Create a new HTTP client
-	client := &http.Client{}
+func HttpRequest(ctx context.Context, opts Options, url string) (*http.Response, error) {
+	return opts.client().do(ctx, "GET", url, nil, nil)
+}
 
-	// Create a new request with a User-Agent header
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
+// HttpHeadRequest asks for the headers of url without its body
+func HttpHeadRequest(ctx context.Context, opts Options, url string) (*http.Response, error) {
+	return opts.client().do(ctx, "HEAD", url, nil, nil)
+}
 
-	// Set headers to mimic a Chrome browser
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.85 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Connection", "keep-alive")
+// fetchRequest sends the request that downloads url itself, using the
+// method and body of --method or --post-data. With --keep-encoded its body
+// is left as the server encoded it.
+func fetchRequest(ctx context.Context, opts Options, url string, header http.Header) (*http.Response, error) {
+	client := opts.client()
+	return client.send(ctx, client.fetchMethod(), url, header, client.config.Body, client.config.KeepEncoded)
+}
 
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+// rangeHeader asks for a file starting at byte offset. When validator (an
+// ETag or a Last-Modified date) is set it is sent as If-Range so that a
+// server whose copy has changed replies with the full body instead.
+func rangeHeader(offset int64, validator string) http.Header {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if validator != "" {
+		header.Set("If-Range", validator)
 	}
-
-	return resp, err
+	return header
 }
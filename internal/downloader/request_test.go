@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -69,7 +70,7 @@ func TestHttpRequest(t *testing.T) {
 			server := httptest.NewServer(http.HandlerFunc(tt.serverFunc))
 			defer server.Close()
 
-			got, err := HttpRequest(server.URL)
+			got, err := HttpRequest(context.Background(), Options{}, server.URL)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("HttpRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
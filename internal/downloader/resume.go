@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// metaSuffix names the sidecar file that sits next to a download while it is
+// in progress. It records the validators of the response so that a later
+// --continue run can check the server still has the same file.
+const metaSuffix = ".wiget"
+
+// partSuffix names the file a download is written to. It is renamed to the
+// final name only once the whole body has been received and verified, so a
+// file under its final name is always complete.
+const partSuffix = ".part"
+
+type resumeMeta struct {
+	ETag         string
+	LastModified string
+}
+
+func metaPath(file string) string {
+	return file + metaSuffix
+}
+
+// PartPath returns the name file is saved under while it downloads
+func PartPath(file string) string {
+	return file + partSuffix
+}
+
+// saveResumeMeta stores the validators found in the response header next to
+// the .part file of file
+func saveResumeMeta(file string, header http.Header) error {
+	data := fmt.Sprintf("ETag: %s\nLast-Modified: %s\n", header.Get("ETag"), header.Get("Last-Modified"))
+	if err := os.WriteFile(metaPath(file), []byte(data), 0o644); err != nil {
+		return fmt.Errorf("error saving resume state: %v", err)
+	}
+	return nil
+}
+
+func loadResumeMeta(file string) resumeMeta {
+	var meta resumeMeta
+	f, err := os.Open(metaPath(file))
+	if err != nil {
+		return meta
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "ETag":
+			meta.ETag = strings.TrimSpace(value)
+		case "Last-Modified":
+			meta.LastModified = strings.TrimSpace(value)
+		}
+	}
+	return meta
+}
+
+// removeResumeMeta drops the validators saved for file
+func removeResumeMeta(file string) {
+	os.Remove(metaPath(file))
+}
+
+// ifRange picks the validator to send in an If-Range header. Weak ETags are
+// not allowed there, so Last-Modified is used instead.
+func (m resumeMeta) ifRange() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// HasPartial reports whether an interrupted download of file left its .part
+// file behind
+func HasPartial(file string) bool {
+	info, err := os.Stat(PartPath(file))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// ResumeOffset returns the number of bytes of file already downloaded, or 0
+// when there is nothing to continue from.
+func ResumeOffset(file string) int64 {
+	info, err := os.Stat(PartPath(file))
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// adoptFile turns a file already saved under its final name, say one fetched
+// by another program, into the .part file of a download so that -c continues
+// it as wget does. It reports whether the file was moved.
+func adoptFile(file string) bool {
+	if HasPartial(file) {
+		return false
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return os.Rename(file, PartPath(file)) == nil
+}
+
+// partialOffset decides where a download of file starts. A .part file is
+// resumed when continuing was asked for, or when the validators saved with it
+// let the server confirm it still has the same file; any other stale .part
+// file is discarded.
+func partialOffset(log io.Writer, file string, continuing bool) int64 {
+	if !HasPartial(file) {
+		return 0
+	}
+	if continuing || loadResumeMeta(file).ifRange() != "" {
+		return ResumeOffset(file)
+	}
+	fmt.Fprintf(log, "removing stale partial file %s\n", PartPath(file))
+	RemovePartial(file)
+	return 0
+}
+
+// ResumeRequest requests url, asking only for the bytes after offset when a
+// previous attempt already saved part of file.
+func ResumeRequest(ctx context.Context, opts Options, url, file string, offset int64) (*http.Response, error) {
+	if offset <= 0 {
+		return fetchRequest(ctx, opts, url, nil)
+	}
+	return fetchRequest(ctx, opts, url, rangeHeader(offset, loadResumeMeta(file).ifRange()))
+}
+
+// AlreadyComplete reports whether the server rejected a resume request
+// because the local file already holds the whole resource.
+func AlreadyComplete(resp *http.Response, offset int64) bool {
+	return offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable
+}
+
+// OpenOutput opens the .part file of file for writing the body of resp. A 206
+// response that starts at offset is appended to the data already on disk; any
+// other response replaces it and the returned offset is 0.
+func OpenOutput(file string, resp *http.Response, offset int64) (*os.File, int64, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, err
+		}
+		if start != offset {
+			return nil, 0, fmt.Errorf("server resumed at byte %d, expected %d", start, offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	out, err := os.OpenFile(PartPath(file), flags, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := saveResumeMeta(file, resp.Header); err != nil {
+		out.Close()
+		return nil, 0, err
+	}
+	return out, offset, nil
+}
+
+// CloseOutput flushes a finished download to disk and closes it
+func CloseOutput(out *os.File) error {
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing to file: %w", err)
+	}
+	return out.Close()
+}
+
+// FinishOutput moves the completed .part file of file into place
+func FinishOutput(file string) error {
+	if err := os.Rename(PartPath(file), file); err != nil {
+		return fmt.Errorf("error saving file: %w", err)
+	}
+	removeResumeMeta(file)
+	return nil
+}
+
+// RemovePartial deletes an unfinished download of file and its resume data
+func RemovePartial(file string) {
+	removeResumeMeta(file)
+	os.Remove(PartPath(file))
+}
+
+// contentRangeStart returns the first byte position of a header such as
+// "bytes 100-199/200".
+func contentRangeStart(header string) (int64, error) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	start, _, found := strings.Cut(strings.TrimPrefix(header, "bytes "), "-")
+	if !found {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return n, nil
+}
+
+// contentRangeSize returns the complete length given by a header such as
+// "bytes 100-199/200", or -1 when it is unknown
+func contentRangeSize(header string) int64 {
+	_, total, found := strings.Cut(header, "/")
+	if !found {
+		return -1
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
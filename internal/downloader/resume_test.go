@@ -0,0 +1,231 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newResumeServer serves content with the given ETag, honouring Range and
+// If-Range requests unless ranges is false.
+func newResumeServer(content []byte, etag string, ranges bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestOneDownloadContinue(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+
+	tests := []struct {
+		name    string
+		ranges  bool
+		etag    string
+		partial []byte
+		metaTag string
+	}{
+		{
+			name:    "Resumes from the end of the partial file",
+			ranges:  true,
+			etag:    `"v1"`,
+			partial: content[:4000],
+			metaTag: `"v1"`,
+		},
+		{
+			name:    "Restarts when the file changed on the server",
+			ranges:  true,
+			etag:    `"v2"`,
+			partial: []byte(strings.Repeat("x", 4000)),
+			metaTag: `"v1"`,
+		},
+		{
+			name:    "Restarts when the server ignores ranges",
+			ranges:  false,
+			partial: []byte(strings.Repeat("x", 4000)),
+		},
+		{
+			name:    "Nothing to do when the file is complete",
+			ranges:  true,
+			etag:    `"v1"`,
+			partial: content,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newResumeServer(content, tt.etag, tt.ranges)
+			defer server.Close()
+
+			dir := t.TempDir()
+			file := filepath.Join(dir, "file.bin")
+			if err := os.WriteFile(file, tt.partial, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.metaTag != "" {
+				if err := os.WriteFile(metaPath(file), []byte("ETag: "+tt.metaTag+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			captureOutput(func() {
+				OneDownload(context.Background(), "file.bin", server.URL+"/file.bin", Options{Directory: dir, Continue: true})
+			})
+
+			got, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded %d bytes, want the %d original bytes", len(got), len(content))
+			}
+			if HasPartial(file) {
+				t.Errorf("resume state for %s was not removed", file)
+			}
+		})
+	}
+}
+
+func TestAsyncDownloadContinue(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 500))
+	server := newResumeServer(content, `"v1"`, true)
+	defer server.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(file, content[:1234], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	captureOutput(func() {
+		AsyncDownload(context.Background(), "", server.URL+"/file.bin", Options{Directory: dir, Continue: true})
+	})
+
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, want the %d original bytes", len(got), len(content))
+	}
+}
+
+func TestDownloadStalePart(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server := newResumeServer(content, `"v1"`, true)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		partial   []byte
+		meta      string
+		wantBytes int64 // received from the server
+	}{
+		{name: "Resumed when its validators were saved", partial: content[:4000], meta: "ETag: \"v1\"\n", wantBytes: 6000},
+		{name: "Discarded without validators", partial: []byte(strings.Repeat("x", 4000)), wantBytes: 10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "file.bin")
+			if err := os.WriteFile(PartPath(file), tt.partial, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.meta != "" {
+				if err := os.WriteFile(metaPath(file), []byte(tt.meta), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := Download(context.Background(), Request{URL: server.URL + "/file.bin"}, Options{Directory: dir})
+			if err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			if res.Bytes != tt.wantBytes {
+				t.Errorf("Download() received %d bytes, want %d", res.Bytes, tt.wantBytes)
+			}
+			got, _ := os.ReadFile(file)
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded %d bytes, want the %d original bytes", len(got), len(content))
+			}
+			if HasPartial(file) {
+				t.Errorf("%s was left behind", PartPath(file))
+			}
+		})
+	}
+}
+
+func TestDownloadContinueKeepsFileOnError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(file, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Download(context.Background(), Request{URL: server.URL + "/file.bin"}, Options{Directory: dir, Continue: true}); err == nil {
+		t.Fatal("Download() succeeded, want a 404 error")
+	}
+	if got, _ := os.ReadFile(file); string(got) != "keep me" {
+		t.Errorf("%s = %q, want it left untouched", file, got)
+	}
+	if HasPartial(file) {
+		t.Errorf("%s was left behind", PartPath(file))
+	}
+}
+
+func Test_contentRangeStart(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    int64
+		wantErr bool
+	}{
+		{header: "bytes 100-199/200", want: 100},
+		{header: "bytes 0-0/*", want: 0},
+		{header: "bytes */200", wantErr: true},
+		{header: "items 1-2/3", wantErr: true},
+		{header: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := contentRangeStart(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("contentRangeStart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("contentRangeStart() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resumeMeta_ifRange(t *testing.T) {
+	tests := []struct {
+		name string
+		meta resumeMeta
+		want string
+	}{
+		{name: "Strong ETag", meta: resumeMeta{ETag: `"abc"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}, want: `"abc"`},
+		{name: "Weak ETag falls back to date", meta: resumeMeta{ETag: `W/"abc"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}, want: "Mon, 02 Jan 2006 15:04:05 GMT"},
+		{name: "No validators", meta: resumeMeta{}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.meta.ifRange(); got != tt.want {
+				t.Errorf("ifRange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Defaults used by the command line when --tries or --waitretry are not given
+const (
+	DefaultTries     = 20
+	DefaultWaitRetry = 10 * time.Second
+)
+
+// firstBackoff is the pause before the second attempt; it doubles after every
+// failure until it reaches Options.WaitRetry.
+const firstBackoff = time.Second
+
+// maxRetryAfter is the longest Retry-After that is waited for, unless
+// Options.WaitRetry allows more. A server asking for longer is given up on.
+const maxRetryAfter = 5 * time.Minute
+
+// StatusError is returned when the server answers with a status that does not
+// carry the requested file.
+type StatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+	RetryAfter time.Duration // how long the server asked us to wait, if at all
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %s url: [%s]", e.Status, RedactURL(e.URL))
+}
+
+// NewStatusError describes the unexpected response resp to a request for url
+func NewStatusError(url string, resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        url,
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// IsRetryable reports whether a failed attempt is worth repeating. Server
+// errors, throttling, timeouts and dropped connections are; missing files,
+// refused access, DNS failures and local I/O errors are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// The user stopped the download, trying again would only ignore them
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return statusErr.StatusCode >= 500
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// A certificate that is not trusted now will not be on the next attempt
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidCert) || errors.Is(err, errPinMismatch) {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Retry calls attempt until it succeeds, fails with an error that is not
+// retryable, or opts.Tries attempts have been made. Between attempts it waits
+// for the Retry-After the server asked for, or an exponential backoff with
+// jitter capped at opts.WaitRetry. It gives up as soon as ctx is cancelled,
+// or when the server asks for a wait longer than maxRetryAfter.
+func Retry(ctx context.Context, opts Options, url string, attempt func() error) error {
+	tries := opts.Tries
+	if tries < 1 {
+		tries = 1
+	}
+
+	var err error
+	for try := 1; ; try++ {
+		err = attempt()
+		if err == nil || try >= tries || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := backoff(try, opts.WaitRetry)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			if limit := max(opts.WaitRetry, maxRetryAfter); statusErr.RetryAfter > limit {
+				return fmt.Errorf("%w: the server asked to retry in %s, longer than %s", err, statusErr.RetryAfter.Round(time.Second), limit)
+			}
+			wait = statusErr.RetryAfter
+		}
+		fmt.Fprintf(opts.log(), "Retrying [%s] in %s (attempt %d of %d): %v\n", RedactURL(url), wait.Round(time.Millisecond), try+1, tries, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the pause after the given failed attempt: firstBackoff
+// doubled for every earlier failure, capped at max, with up to half of it
+// taken off at random so parallel downloads do not retry in lockstep.
+func backoff(try int, max time.Duration) time.Duration {
+	if max <= 0 {
+		max = DefaultWaitRetry
+	}
+	wait := firstBackoff
+	for i := 1; i < try && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
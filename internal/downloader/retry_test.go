@@ -0,0 +1,208 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "Empty", value: "", want: 0},
+		{name: "Seconds", value: "120", want: 2 * time.Minute},
+		{name: "Negative seconds", value: "-5", want: 0},
+		{name: "HTTP date", value: "Mon, 01 Jan 2024 12:00:30 GMT", want: 30 * time.Second},
+		{name: "Date in the past", value: "Mon, 01 Jan 2024 11:00:00 GMT", want: 0},
+		{name: "Garbage", value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "No error", err: nil, want: false},
+		{name: "Service unavailable", err: &StatusError{StatusCode: 503}, want: true},
+		{name: "Internal server error", err: &StatusError{StatusCode: 500}, want: true},
+		{name: "Too many requests", err: &StatusError{StatusCode: 429}, want: true},
+		{name: "Not found", err: &StatusError{StatusCode: 404}, want: false},
+		{name: "Forbidden", err: &StatusError{StatusCode: 403}, want: false},
+		{name: "Short body", err: fmt.Errorf("error reading response body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "Connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "Unknown host", err: fmt.Errorf("error sending request: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), want: false},
+		{name: "Local file error", err: fmt.Errorf("error creating file: %w", os.ErrPermission), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	max := 8 * time.Second
+	for try := 1; try <= 6; try++ {
+		want := firstBackoff << (try - 1)
+		if want > max {
+			want = max
+		}
+		got := backoff(try, max)
+		if got < want/2 || got > want {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", try, got, want/2, want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		tries        int
+		failures     []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "Succeeds after transient failures",
+			tries:        3,
+			failures:     []error{&StatusError{StatusCode: 503}, io.ErrUnexpectedEOF},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Gives up after the last try",
+			tries:        2,
+			failures:     []error{&StatusError{StatusCode: 502}, &StatusError{StatusCode: 502}, &StatusError{StatusCode: 502}},
+			wantAttempts: 2,
+			wantErr:      true,
+		},
+		{
+			name:         "Does not retry fatal errors",
+			tries:        5,
+			failures:     []error{&StatusError{StatusCode: 404}},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "Gives up when the server asks for a long wait",
+			tries:        3,
+			failures:     []error{&StatusError{StatusCode: 503, RetryAfter: time.Hour}},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "Zero tries still makes one attempt",
+			tries:        0,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			var err error
+			captureOutput(func() {
+				err = Retry(context.Background(), Options{Tries: tt.tries, WaitRetry: time.Millisecond}, "http://example.com", func() error {
+					attempts++
+					if attempts <= len(tt.failures) {
+						return tt.failures[attempts-1]
+					}
+					return nil
+				})
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Retry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Retry() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := Retry(ctx, Options{Tries: 5, WaitRetry: time.Hour}, "http://example.com", func() error {
+		attempts++
+		cancel()
+		return &StatusError{StatusCode: 503}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Retry() = %v after %d attempts, want the first error and no retry", err, attempts)
+	}
+}
+
+func TestOneDownloadRetry(t *testing.T) {
+	content := []byte(strings.Repeat("retry me ", 2000))
+
+	var mu sync.Mutex
+	var ranges []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Send half of the body, then drop the connection
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	captureOutput(func() {
+		OneDownload(context.Background(), "file.bin", server.URL+"/file.bin", Options{Directory: dir, Tries: 3, WaitRetry: time.Millisecond})
+	})
+
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded %d bytes, want the %d original bytes", len(got), len(content))
+	}
+	if requests != 3 {
+		t.Fatalf("server saw %d requests, want 3", requests)
+	}
+	if want := fmt.Sprintf("bytes=%d-", len(content)/2); ranges[2] != want {
+		t.Errorf("third request asked for Range %q, want %q", ranges[2], want)
+	}
+}
@@ -0,0 +1,208 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// builtinSchemes are served by the client itself and cannot be registered
+var builtinSchemes = map[string]bool{"http": true, "https": true, "ftp": true, "ftps": true}
+
+// localSchemes read data from this machine rather than from the network, so
+// a server may not redirect to them
+var localSchemes = map[string]bool{"file": true, "data": true}
+
+// fetchers serves the other URL schemes. Every HTTPClient created afterwards
+// fetches them with the fetcher registered here.
+var (
+	fetchersMu sync.Mutex
+	fetchers   = map[string]Fetcher{
+		"file": fileFetcher{},
+		"data": FetcherFunc(openData),
+	}
+)
+
+// RegisterFetcher makes HTTPClients created from now on fetch URLs of scheme
+// with f, replacing any fetcher registered for it before. http, https, ftp
+// and ftps cannot be replaced here; HTTPConfig.Fetchers can replace them for
+// one client, such as in tests.
+func RegisterFetcher(scheme string, f Fetcher) error {
+	scheme = strings.ToLower(scheme)
+	if builtinSchemes[scheme] {
+		return fmt.Errorf("the %s scheme is built in", scheme)
+	}
+	if scheme == "" || f == nil {
+		return errors.New("a scheme needs a name and a fetcher")
+	}
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	fetchers[scheme] = f
+	return nil
+}
+
+// protocols returns the transports of the schemes other than http and https:
+// the registered fetchers and FTP, overridden by the fetchers of config
+func protocols(ftp *ftpTransport, config HTTPConfig) map[string]http.RoundTripper {
+	transports := map[string]http.RoundTripper{"ftp": ftp, "ftps": ftp}
+	fetchersMu.Lock()
+	for scheme, f := range fetchers {
+		transports[scheme] = fetcherTransport{f}
+	}
+	fetchersMu.Unlock()
+	for scheme, f := range config.Fetchers {
+		transports[strings.ToLower(scheme)] = fetcherTransport{f}
+	}
+	return transports
+}
+
+// SourceURL turns a local path such as "./fixtures/page.html" or
+// "/srv/data.csv" into a file:// URL. URLs are returned unchanged.
+func SourceURL(source string) string {
+	if u, err := url.Parse(source); err == nil && len(u.Scheme) > 1 {
+		return source
+	}
+	path, err := filepath.Abs(expandPath(io.Discard, source))
+	if err != nil {
+		return source
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// fileFetcher opens file:// URLs on the local file system. A directory is
+// read as an HTML index of its entries.
+type fileFetcher struct{}
+
+func (fileFetcher) Open(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file: host %q is not this machine", u.Host)
+	}
+	name := filepath.FromSlash(u.Path)
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		defer file.Close()
+		files, err := file.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]dirEntry, 0, len(files))
+		for _, f := range files {
+			entry := dirEntry{Name: f.Name(), Dir: f.IsDir()}
+			if info, err := f.Info(); err == nil {
+				entry.Size, entry.ModTime = info.Size(), info.ModTime()
+			}
+			entries = append(entries, entry)
+		}
+		// Without a trailing slash links are relative to the parent
+		prefix := ""
+		if !strings.HasSuffix(u.Path, "/") {
+			prefix = path.Base(u.Path) + "/"
+		}
+		page := listingPage(u, prefix, entries)
+		return &Stream{
+			Body:        io.NopCloser(strings.NewReader(page)),
+			Size:        int64(len(page)),
+			ContentType: "text/html; charset=utf-8",
+			ModTime:     info.ModTime(),
+		}, nil
+	}
+
+	stream := &Stream{Body: file, Size: info.Size(), ModTime: info.ModTime()}
+	if r.Start > 0 && r.Start < info.Size() {
+		if stream.Offset, err = file.Seek(r.Start, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// openData reads data: URLs (RFC 2397), which carry their content
+func openData(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	contentType, data, err := parseDataURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{
+		Body:        io.NopCloser(bytes.NewReader(data)),
+		Size:        int64(len(data)),
+		ContentType: contentType,
+	}, nil
+}
+
+// parseDataURL reads "data:[<mediatype>][;base64],<data>"
+func parseDataURL(u *url.URL) (string, []byte, error) {
+	raw := u.Opaque
+	if raw == "" {
+		// data://... is not a data URL, but is read as one all the same
+		raw = strings.TrimPrefix(u.String(), u.Scheme+":")
+	}
+	header, payload, found := strings.Cut(raw, ",")
+	if !found {
+		return "", nil, fmt.Errorf("invalid data URL: missing ','")
+	}
+	encoded := strings.HasSuffix(strings.ToLower(header), ";base64")
+	if encoded {
+		header = header[:len(header)-len(";base64")]
+	}
+
+	contentType := "text/plain;charset=US-ASCII"
+	if header != "" {
+		mediaType, params, err := mime.ParseMediaType(header)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid data URL: %v", err)
+		}
+		contentType = mime.FormatMediaType(mediaType, params)
+	}
+
+	text, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URL: %v", err)
+	}
+	if !encoded {
+		return contentType, []byte(text), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimRight(text, "="))
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "="))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URL: %v", err)
+	}
+	return contentType, data, nil
+}
+
+// fetchable reports whether URLs of scheme can be downloaded
+func fetchable(scheme string) bool {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	return builtinSchemes[scheme] || fetchers[scheme] != nil
+}
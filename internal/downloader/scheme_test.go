@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDownloadLocalSchemes(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "report.csv"), []byte("a,b\n1,2\n"), 0o644)
+	os.MkdirAll(filepath.Join(src, "site"), 0o755)
+	os.WriteFile(filepath.Join(src, "site", "index.html"), []byte("<p>home</p>"), 0o644)
+	wd, _ := os.Getwd()
+	relative, _ := filepath.Rel(wd, filepath.Join(src, "report.csv"))
+
+	tests := []struct {
+		name     string
+		url      string
+		wantFile string
+		want     string
+		wantErr  bool
+	}{
+		{name: "file URL", url: "file://" + filepath.ToSlash(src) + "/report.csv", wantFile: "report.csv", want: "a,b\n1,2\n"},
+		{name: "Absolute path", url: filepath.Join(src, "report.csv"), wantFile: "report.csv", want: "a,b\n1,2\n"},
+		{name: "Relative path", url: "./" + relative, wantFile: "report.csv", want: "a,b\n1,2\n"},
+		{name: "index.html is not redirected", url: "file://" + filepath.ToSlash(src) + "/site/index.html", wantFile: "index.html", want: "<p>home</p>"},
+		{name: "Directory listing", url: "file://" + filepath.ToSlash(src) + "/site", wantFile: "site", want: `<a href="site/index.html">index.html</a>`},
+		{name: "Missing file", url: "file://" + filepath.ToSlash(src) + "/missing.csv", wantErr: true},
+		{name: "data URL", url: "data:,Hello%2C%20World%21", wantFile: defaultFileName, want: "Hello, World!"},
+		{name: "Base64 data URL", url: "data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==", wantFile: defaultFileName, want: "Hello, World!"},
+		{name: "Malformed data URL", url: "data:text/plain;base64", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Tries: 1, Log: &bytes.Buffer{}}
+			_, err := Download(context.Background(), Request{URL: tt.url}, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, tt.wantFile)); !strings.Contains(string(data), tt.want) {
+				t.Errorf("%s holds %q, want %q", tt.wantFile, data, tt.want)
+			}
+		})
+	}
+}
+
+func TestResumeLocalFile(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	src := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(src, content, 0o644)
+
+	dir := t.TempDir()
+	os.WriteFile(PartPath(filepath.Join(dir, "data.bin")), content[:300], 0o644)
+	opts := Options{Directory: dir, Tries: 1, Continue: true, Log: &bytes.Buffer{}}
+	if _, err := Download(context.Background(), Request{URL: src}, opts); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "data.bin")); !bytes.Equal(data, content) {
+		t.Errorf("resumed file differs from the original")
+	}
+}
+
+func TestRegisterFetcher(t *testing.T) {
+	memory := FetcherFunc(func(ctx context.Context, rawURL string, r ByteRange) (*Stream, error) {
+		return &Stream{Body: io.NopCloser(strings.NewReader("from memory"))}, nil
+	})
+	if err := RegisterFetcher("http", memory); err == nil {
+		t.Errorf("RegisterFetcher() replaced the built-in http scheme")
+	}
+	if err := RegisterFetcher("mem", memory); err != nil {
+		t.Fatalf("RegisterFetcher() error = %v", err)
+	}
+	defer func() {
+		fetchersMu.Lock()
+		delete(fetchers, "mem")
+		fetchersMu.Unlock()
+	}()
+
+	dir := t.TempDir()
+	opts := Options{Directory: dir, Tries: 1, Log: &bytes.Buffer{}, HTTP: NewHTTPClient(HTTPConfig{})}
+	if _, err := Download(context.Background(), Request{URL: "mem://store/notes.txt"}, opts); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "notes.txt")); string(data) != "from memory" {
+		t.Errorf("notes.txt holds %q", data)
+	}
+}
+
+func TestRedirectToLocalScheme(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0o644)
+	server := httptest.NewServer(http.RedirectHandler("file://"+filepath.ToSlash(secret), http.StatusFound))
+	defer server.Close()
+
+	dir := t.TempDir()
+	opts := Options{Directory: dir, Tries: 1, Log: &bytes.Buffer{}}
+	_, err := Download(context.Background(), Request{URL: server.URL + "/file.txt"}, opts)
+	if !errors.Is(err, errLocalRedirect) {
+		t.Errorf("Download() error = %v, want the redirect refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "secret.txt")); err == nil {
+		t.Errorf("the local file was copied")
+	}
+}
+
+func TestScanListLocalPaths(t *testing.T) {
+	list := "https://example.com/a.iso\n/srv/b.csv sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\ndata:,x\n"
+	var requests []Request
+	err := scanList(strings.NewReader(list), func(req Request) bool {
+		requests = append(requests, req)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Request{
+		{URL: "https://example.com/a.iso"},
+		{URL: "file:///srv/b.csv", Checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{URL: "data:,x"},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("scanList() = %+v, want %+v", requests, want)
+	}
+}
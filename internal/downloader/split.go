@@ -0,0 +1,232 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wiget/internal/rateLimiter"
+)
+
+// minSegmentSize keeps --split from cutting small files into many tiny requests
+const minSegmentSize = 1024 * 1024
+
+// errRangeIgnored is returned when a segment request gets the whole file back,
+// either because the server stopped honouring ranges or the file changed.
+// The download then starts over as a single stream.
+var errRangeIgnored = errors.New("server ignored the range request or the file changed")
+
+// segment is one byte range of a split download
+type segment struct {
+	start, end int64 // inclusive
+	written    int64 // bytes saved so far, updated atomically
+}
+
+// probeRanges asks the server whether url can be fetched in ranges. It returns
+// the size of the file and the response to the HEAD request, whose body is
+// already closed, or a size of -1 when the download has to use a single
+// stream.
+func probeRanges(ctx context.Context, opts Options, url string) (int64, *http.Response) {
+	resp, err := HttpHeadRequest(ctx, opts, url)
+	if err != nil {
+		return -1, nil
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		return -1, nil
+	}
+	if !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return -1, nil
+	}
+	return resp.ContentLength, resp
+}
+
+// splitSegments divides size bytes into at most n ranges of at least
+// minSegmentSize bytes each.
+func splitSegments(size int64, n int) []*segment {
+	if max := int(size / minSegmentSize); n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	segments := make([]*segment, n)
+	chunk := size / int64(n)
+	for i := range segments {
+		start := int64(i) * chunk
+		end := start + chunk - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		segments[i] = &segment{start: start, end: end}
+	}
+	return segments
+}
+
+// contiguousLength returns how many bytes from the start of the file are
+// complete, so that a failed split download can be resumed with --continue.
+func contiguousLength(segments []*segment) int64 {
+	var length int64
+	for _, seg := range segments {
+		written := atomic.LoadInt64(&seg.written)
+		length += written
+		if seg.start+written <= seg.end {
+			break
+		}
+	}
+	return length
+}
+
+// splitDownload fetches url into the .part file of file over several parallel
+// range requests, writing each one in place into a preallocated file.
+func splitDownload(ctx context.Context, file, url string, size int64, header http.Header, opts Options) error {
+	out, err := os.OpenFile(PartPath(file), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	if err := out.Truncate(size); err != nil {
+		out.Close()
+		return fmt.Errorf("error allocating file: %w", err)
+	}
+	if err := saveResumeMeta(file, header); err != nil {
+		out.Close()
+		return err
+	}
+
+	// One bucket for every segment keeps --rate-limit an overall limit
+	var limiter *rateLimiter.Limiter
+	if opts.RateLimit != "" {
+		limiter = rateLimiter.NewLimiter(opts.RateLimit)
+	}
+	validator := resumeMeta{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}.ifRange()
+
+	segments := splitSegments(size, opts.Split)
+	fmt.Fprintf(opts.log(), "downloading in %d segments\n", len(segments))
+
+	startDownload := time.Now()
+	stop := make(chan struct{})
+	var drawn sync.WaitGroup
+	if opts.Progress != nil {
+		drawn.Add(1)
+		go func() {
+			defer drawn.Done()
+			ticker := time.NewTicker(200 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				done := int64(0)
+				for _, seg := range segments {
+					done += atomic.LoadInt64(&seg.written)
+				}
+				opts.Progress(Progress{Done: done, Total: size, Downloaded: done, Started: startDownload})
+
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	// The first segment to fail stops the others, as the download has failed
+	segmentCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, len(segments))
+	for i, seg := range segments {
+		wg.Add(1)
+		go func(i int, seg *segment) {
+			defer wg.Done()
+			if errs[i] = fetchSegment(segmentCtx, out, url, seg, validator, limiter, opts); errs[i] != nil {
+				cancel()
+			}
+		}(i, seg)
+	}
+	wg.Wait()
+	close(stop)
+	drawn.Wait()
+
+	var failed error
+	for _, err := range errs {
+		// Report the failure itself rather than the segments it stopped
+		if err != nil && (failed == nil || ctx.Err() == nil && errors.Is(failed, context.Canceled)) {
+			failed = err
+		}
+	}
+	if failed != nil {
+		// Keep only the complete prefix so --continue can pick up from there
+		out.Truncate(contiguousLength(segments))
+		out.Close()
+		return failed
+	}
+	return CloseOutput(out)
+}
+
+// fetchSegment downloads one range of url into out, retrying from the last
+// byte it saved when the connection fails.
+func fetchSegment(ctx context.Context, out *os.File, url string, seg *segment, validator string, limiter *rateLimiter.Limiter, opts Options) error {
+	return Retry(ctx, opts, url, func() error {
+		start := seg.start + atomic.LoadInt64(&seg.written)
+		if start > seg.end {
+			return nil
+		}
+
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, seg.end))
+		if validator != "" {
+			header.Set("If-Range", validator)
+		}
+		resp, err := opts.client().do(ctx, "GET", url, header, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return errRangeIgnored
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			return NewStatusError(url, resp)
+		}
+		if got, err := contentRangeStart(resp.Header.Get("Content-Range")); err != nil || got != start {
+			return fmt.Errorf("server sent the wrong range for bytes %d-%d", start, seg.end)
+		}
+
+		var reader io.Reader = io.LimitReader(resp.Body, seg.end-start+1)
+		if limiter != nil {
+			reader = limiter.NewReader(ctx, reader)
+		}
+
+		buffer := make([]byte, 32*1024) // 32 KB buffer size
+		for {
+			n, err := reader.Read(buffer)
+			if n > 0 {
+				if _, err := out.WriteAt(buffer[:n], start); err != nil {
+					return fmt.Errorf("error writing to file: %w", err)
+				}
+				start += int64(n)
+				atomic.AddInt64(&seg.written, int64(n))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading response body: %w", err)
+			}
+		}
+
+		if start <= seg.end {
+			return fmt.Errorf("error reading response body: %w", io.ErrUnexpectedEOF)
+		}
+		return nil
+	})
+}
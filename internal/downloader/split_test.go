@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_splitSegments(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int64
+		n            int
+		wantSegments int
+	}{
+		{name: "Even split", size: 4 * minSegmentSize, n: 4, wantSegments: 4},
+		{name: "Uneven split", size: 3*minSegmentSize + 17, n: 3, wantSegments: 3},
+		{name: "Capped by segment size", size: 2 * minSegmentSize, n: 8, wantSegments: 2},
+		{name: "Small file", size: 10, n: 4, wantSegments: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := splitSegments(tt.size, tt.n)
+			if len(segments) != tt.wantSegments {
+				t.Fatalf("splitSegments() made %d segments, want %d", len(segments), tt.wantSegments)
+			}
+			// The segments must cover every byte exactly once
+			next := int64(0)
+			for _, seg := range segments {
+				if seg.start != next {
+					t.Fatalf("segment starts at %d, want %d", seg.start, next)
+				}
+				next = seg.end + 1
+			}
+			if next != tt.size {
+				t.Errorf("segments end at %d, want %d", next, tt.size)
+			}
+		})
+	}
+}
+
+func Test_contiguousLength(t *testing.T) {
+	segments := []*segment{
+		{start: 0, end: 9, written: 10},
+		{start: 10, end: 19, written: 4},
+		{start: 20, end: 29, written: 10},
+	}
+	if got := contiguousLength(segments); got != 14 {
+		t.Errorf("contiguousLength() = %d, want 14", got)
+	}
+}
+
+func TestOneDownloadSplit(t *testing.T) {
+	content := make([]byte, 3*minSegmentSize+123)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	tests := []struct {
+		name         string
+		ranges       bool
+		ignoreRange  bool // ranges are advertised but a GET gets the whole file
+		wantRequests int  // GET requests, not counting the HEAD probe; 0 for any
+	}{
+		{name: "Fetches the file in segments", ranges: true, wantRequests: 3},
+		{name: "Falls back to one stream without Accept-Ranges", ranges: false, wantRequests: 1},
+		{name: "Falls back to one stream when a segment gets the whole file", ranges: true, ignoreRange: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			gets := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					mu.Lock()
+					gets++
+					mu.Unlock()
+				}
+				if !tt.ranges {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content)
+					return
+				}
+				if tt.ignoreRange && r.Method == "GET" {
+					r.Header.Del("Range")
+				}
+				http.ServeContent(w, r, "big.iso", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			captureOutput(func() {
+				OneDownload(context.Background(), "big.iso", server.URL+"/big.iso", Options{Directory: dir, Split: 3})
+			})
+
+			got, err := os.ReadFile(filepath.Join(dir, "big.iso"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("downloaded file differs from the original (%d bytes, want %d)", len(got), len(content))
+			}
+			if tt.wantRequests > 0 && gets != tt.wantRequests {
+				t.Errorf("server saw %d GET requests, want %d", gets, tt.wantRequests)
+			}
+			if HasPartial(filepath.Join(dir, "big.iso")) {
+				t.Errorf("resume state was not removed")
+			}
+		})
+	}
+}
+
+func TestSplitDownloadStopsOnFailure(t *testing.T) {
+	size := int64(3 * minSegmentSize)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		// The other segments stall until their request is cancelled
+		w.Header().Set("Content-Range", strings.Replace(r.Header.Get("Range"), "=", " ", 1)+"/"+strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "big.iso")
+	start := time.Now()
+	err := splitDownload(context.Background(), file, server.URL+"/big.iso", size, http.Header{}, Options{Split: 3, Tries: 1})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("splitDownload() error = %v, want the status of the failed segment", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("splitDownload() took %v, want the other segments stopped", elapsed)
+	}
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TimestampRequest requests url for the local copy file, asking the server to
+// answer 304 Not Modified when that copy is current. The modification time of
+// file, set from Last-Modified when it was downloaded, is sent as
+// If-Modified-Since and the ETag saved with it, if any, as If-None-Match.
+func TimestampRequest(ctx context.Context, opts Options, url, file string) (*http.Response, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fetchRequest(ctx, opts, url, nil)
+	}
+
+	header := http.Header{}
+	header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	if etag := loadResumeMeta(file).ETag; etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	return fetchRequest(ctx, opts, url, header)
+}
+
+// NotModified reports whether resp, the answer to a TimestampRequest, says
+// the local copy file is current. Besides 304, a server that ignored the
+// conditions is trusted when its Last-Modified is not newer than file and the
+// sizes match.
+func NotModified(resp *http.Response, file string) bool {
+	if resp.StatusCode == http.StatusNotModified {
+		return true
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil || modified.After(info.ModTime()) {
+		return false
+	}
+	return resp.ContentLength < 0 || resp.ContentLength == info.Size()
+}
+
+// SaveTimestamp gives a finished download of file the modification time of
+// its Last-Modified header, which the next -N run compares against, and keeps
+// its ETag in the .wiget file next to it for If-None-Match. A file served
+// without an ETag has nothing kept next to it.
+func SaveTimestamp(file string, header http.Header) error {
+	if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		if err := os.Chtimes(file, time.Now(), modified); err != nil {
+			return err
+		}
+	}
+	if header.Get("ETag") == "" {
+		removeResumeMeta(file)
+		return nil
+	}
+	return saveResumeMeta(file, header)
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDownloadTimestamping(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	content := []byte("daily report")
+
+	tests := []struct {
+		name        string
+		conditional bool      // the server honours If-Modified-Since
+		changed     time.Time // Last-Modified of the second run
+		wantRefetch bool
+	}{
+		{name: "304 when unchanged", conditional: true, changed: modified},
+		{name: "Same date and size when conditions are ignored", changed: modified},
+		{name: "Fetched again when newer", conditional: true, changed: modified.Add(time.Hour), wantRefetch: true},
+		{name: "Fetched again when newer and conditions are ignored", changed: modified.Add(time.Hour), wantRefetch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastModified := modified
+			var gotETag, gotSince string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"`+strconv.FormatInt(lastModified.Unix(), 10)+`"`)
+				gotETag, gotSince = r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+				if tt.conditional {
+					http.ServeContent(w, r, "report.txt", lastModified, bytes.NewReader(content))
+					return
+				}
+				w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+				w.Write(content)
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			opts := Options{Directory: dir, Timestamping: true}
+			req := Request{URL: server.URL + "/report.txt"}
+			if _, err := Download(context.Background(), req, opts); err != nil {
+				t.Fatalf("first Download() error = %v", err)
+			}
+			output := filepath.Join(dir, "report.txt")
+			info, err := os.Stat(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(modified) {
+				t.Errorf("mtime = %s, want the Last-Modified %s", info.ModTime().UTC(), modified)
+			}
+
+			lastModified = tt.changed
+			res, err := Download(context.Background(), req, opts)
+			if err != nil {
+				t.Fatalf("second Download() error = %v", err)
+			}
+			if res.UpToDate == tt.wantRefetch {
+				t.Errorf("UpToDate = %v, want %v", res.UpToDate, !tt.wantRefetch)
+			}
+			if tt.wantRefetch != (res.Bytes > 0) {
+				t.Errorf("second run received %d bytes", res.Bytes)
+			}
+			if gotSince != modified.Format(http.TimeFormat) {
+				t.Errorf("If-Modified-Since = %q, want the file time %q", gotSince, modified.Format(http.TimeFormat))
+			}
+			if want := `"` + strconv.FormatInt(modified.Unix(), 10) + `"`; gotETag != want {
+				t.Errorf("If-None-Match = %q, want the stored ETag %q", gotETag, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pinPrefix starts a public key pin, as in curl's --pinnedpubkey
+const pinPrefix = "sha256//"
+
+// errPinMismatch rejects a server whose key is not one of the pinned ones
+var errPinMismatch = errors.New("server public key does not match any pinned key")
+
+// TLSOptions are the certificate settings of the command line
+type TLSOptions struct {
+	CACertificate      string // PEM bundle of extra trusted authorities (--ca-certificate)
+	CADirectory        string // directory of PEM files of extra trusted authorities (--ca-directory)
+	Certificate        string // PEM client certificate for mutual TLS (--certificate)
+	PrivateKey         string // PEM key of Certificate, read from Certificate when empty (--private-key)
+	NoCheckCertificate bool   // accept any server certificate (--no-check-certificate)
+	MinVersion         string // lowest TLS version allowed, "1.0" to "1.3", 1.2 when empty
+	PinnedPublicKeys   string // "sha256//<base64>" hashes separated by ";" (--pinned-pubkey)
+}
+
+// NewTLSConfig builds the TLS configuration for HTTPConfig.TLS. The
+// authorities given are trusted on top of the system ones. With pins, the
+// server must also present a certificate whose public key has one of the
+// pinned SHA-256 hashes, even when --no-check-certificate is set.
+func NewTLSConfig(o TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.NoCheckCertificate}
+
+	version, err := ParseTLSVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config.MinVersion = version
+
+	if o.CACertificate != "" || o.CADirectory != "" {
+		if config.RootCAs, err = loadRootCAs(o.CACertificate, o.CADirectory); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Certificate != "" {
+		key := o.PrivateKey
+		if key == "" {
+			key = o.Certificate
+		}
+		cert, err := tls.LoadX509KeyPair(o.Certificate, key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if o.PrivateKey != "" {
+		return nil, errors.New("a private key needs a certificate to go with it")
+	}
+
+	if o.PinnedPublicKeys != "" {
+		pins, err := parsePins(o.PinnedPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return checkPins(state, pins)
+		}
+	}
+	return config, nil
+}
+
+// ParseTLSVersion turns "1.0", "1.1", "1.2" or "1.3" into the matching tls
+// constant. An empty version means TLS 1.2.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tlsv") {
+	case "1.0", "1":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, use 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+// loadRootCAs returns the system pool extended with the certificates of
+// bundle and of the files in dir
+func loadRootCAs(bundle, dir string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if bundle != "" {
+		data, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("error loading CA certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", bundle)
+		}
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error loading CA directory: %w", err)
+		}
+		found := false
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			// Other files, such as a README, are skipped
+			if pool.AppendCertsFromPEM(data) {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no PEM certificates found in %s", dir)
+		}
+	}
+	return pool, nil
+}
+
+// parsePins reads "sha256//<base64>;sha256//<base64>" into raw hashes
+func parsePins(value string) ([][]byte, error) {
+	var pins [][]byte
+	for _, pin := range strings.Split(value, ";") {
+		pin = strings.TrimSpace(pin)
+		if pin == "" {
+			continue
+		}
+		if !strings.HasPrefix(pin, pinPrefix) {
+			return nil, fmt.Errorf("invalid pin %q, expected sha256//<base64>", pin)
+		}
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q, expected sha256//<base64>", pin)
+		}
+		pins = append(pins, hash)
+	}
+	return pins, nil
+}
+
+// checkPins accepts a connection when the certificate of the server has a
+// pinned public key. When the chain was verified, an authority of the chain
+// may be pinned instead. Anything else the server sent proves nothing: it
+// could append the real certificate of another server to its own.
+func checkPins(state tls.ConnectionState, pins [][]byte) error {
+	var certs []*x509.Certificate
+	for _, chain := range state.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+		certs = state.PeerCertificates[:1]
+	}
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if string(hash[:]) == string(pin) {
+				return nil
+			}
+		}
+	}
+	return errPinMismatch
+}
+
+// publicKeyPin returns the pin of cert in the sha256//<base64> form
+func publicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
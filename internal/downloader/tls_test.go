@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, written to PEM files for the flags
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func (c testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil, and saves it in dir
+func newTestCert(t *testing.T, dir, name string, parent *testCert) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	c := testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".pem"), keyFile: filepath.Join(dir, name+".key")}
+	os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return c
+}
+
+func TestDownloadTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "server", &ca)
+	clientCert := newTestCert(t, dir, "client", &ca)
+	// An attacker sends its own certificate with the real one appended
+	attacker := newTestCert(t, dir, "attacker", nil)
+	attackerChain := tls.Certificate{Certificate: [][]byte{attacker.cert.Raw, serverCert.cert.Raw}, PrivateKey: attacker.key}
+	caDir := filepath.Join(dir, "authorities")
+	os.Mkdir(caDir, 0o755)
+	os.WriteFile(filepath.Join(caDir, "README"), []byte("not a certificate"), 0o644)
+	caPEM, _ := os.ReadFile(ca.certFile)
+	os.WriteFile(filepath.Join(caDir, "ca.pem"), caPEM, 0o644)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	tests := []struct {
+		name       string
+		options    TLSOptions
+		mutual     bool             // the server asks for a client certificate
+		maxVersion uint16           // highest version the server speaks, 0 for the default
+		chain      *tls.Certificate // what the server presents, serverCert when nil
+		wantErr    bool
+	}{
+		{name: "Unknown authority", wantErr: true},
+		{name: "CA bundle", options: TLSOptions{CACertificate: ca.certFile}},
+		{name: "CA directory", options: TLSOptions{CADirectory: caDir}},
+		{name: "No certificate check", options: TLSOptions{NoCheckCertificate: true}},
+		{name: "Client certificate", options: TLSOptions{CACertificate: ca.certFile, Certificate: clientCert.certFile, PrivateKey: clientCert.keyFile}, mutual: true},
+		{name: "Missing client certificate", options: TLSOptions{CACertificate: ca.certFile}, mutual: true, wantErr: true},
+		{name: "Pinned key", options: TLSOptions{CACertificate: ca.certFile, PinnedPublicKeys: "sha256//AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=;" + publicKeyPin(serverCert.cert)}},
+		{name: "Pinned key without certificate check", options: TLSOptions{NoCheckCertificate: true, PinnedPublicKeys: publicKeyPin(serverCert.cert)}},
+		{name: "Pin mismatch", options: TLSOptions{CACertificate: ca.certFile, PinnedPublicKeys: publicKeyPin(clientCert.cert)}, wantErr: true},
+		{name: "Pin mismatch without certificate check", options: TLSOptions{NoCheckCertificate: true, PinnedPublicKeys: publicKeyPin(ca.cert)}, wantErr: true},
+		{name: "Pinned authority of a verified chain", options: TLSOptions{CACertificate: ca.certFile, PinnedPublicKeys: publicKeyPin(ca.cert)}},
+		{name: "Pinned certificate appended by an attacker", options: TLSOptions{NoCheckCertificate: true, PinnedPublicKeys: publicKeyPin(serverCert.cert)}, chain: &attackerChain, wantErr: true},
+		{name: "Server below the minimum version", options: TLSOptions{CACertificate: ca.certFile, MinVersion: "1.3"}, maxVersion: tls.VersionTLS12, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("secret"))
+			}))
+			certificate := serverCert.tlsCertificate()
+			if tt.chain != nil {
+				certificate = *tt.chain
+			}
+			server.TLS = &tls.Config{
+				Certificates: []tls.Certificate{certificate},
+				MaxVersion:   tt.maxVersion,
+			}
+			if tt.mutual {
+				server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+				server.TLS.ClientCAs = clientCAs
+			}
+			server.Config.ErrorLog = log.New(io.Discard, "", 0)
+			server.StartTLS()
+			defer server.Close()
+
+			config, err := NewTLSConfig(tt.options)
+			if err != nil {
+				t.Fatalf("NewTLSConfig() error = %v", err)
+			}
+			opts := Options{Directory: t.TempDir(), Tries: 1, HTTP: NewHTTPClient(HTTPConfig{TLS: config})}
+			_, err = Download(context.Background(), Request{URL: server.URL + "/file.txt"}, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// Certificates the client rejects itself are not worth retrying
+			if err != nil && IsRetryable(err) && !tt.mutual && tt.maxVersion == 0 {
+				t.Errorf("a certificate error should not be retried: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "bundle.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o644)
+
+	tests := []struct {
+		name    string
+		options TLSOptions
+	}{
+		{name: "Missing bundle", options: TLSOptions{CACertificate: filepath.Join(dir, "missing.pem")}},
+		{name: "Bundle without certificates", options: TLSOptions{CACertificate: notPEM}},
+		{name: "Directory without certificates", options: TLSOptions{CADirectory: dir}},
+		{name: "Key without certificate", options: TLSOptions{PrivateKey: notPEM}},
+		{name: "Unknown version", options: TLSOptions{MinVersion: "1.4"}},
+		{name: "Malformed pin", options: TLSOptions{PinnedPublicKeys: "sha1//abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTLSConfig(tt.options); err == nil {
+				t.Errorf("NewTLSConfig() accepted %+v", tt.options)
+			}
+		})
+	}
+}
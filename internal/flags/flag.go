@@ -4,30 +4,90 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// exitParse is the status of a command line that cannot be parsed, as in wget
+const exitParse = 2
+
 // Inputs struct with exported fields (Uppercase names)
 type Inputs struct {
-	URL              string
-	File             string
-	RateLimit        string
-	Path             string
-	Sourcefile       string
-	WorkInBackground bool
-	Mirroring        bool // Capitalized "Mirroring"
-	RejectFlag       string
-	ExcludeFlag      string
-	ConvertLinksFlag bool
+	URL                 string
+	File                string
+	RateLimit           string
+	Path                string
+	Sourcefile          string
+	WorkInBackground    bool
+	Mirroring           bool // Capitalized "Mirroring"
+	RejectFlag          string
+	ExcludeFlag         string
+	ConvertLinksFlag    bool
+	Continue            bool
+	Tries               int // 0 means the default number of attempts
+	WaitRetry           int // seconds, 0 means the default backoff cap
+	Split               int // parallel connections per file, 0 means one
+	Checksum            string
+	Quarantine          bool
+	RemovePartial       bool          // delete unfinished files on Ctrl-C instead of keeping them for -c
+	NoClobber           bool          // keep existing files and skip their download
+	Numbered            bool          // save over existing files as file.1, file.2, ...
+	Backups             int           // old copies to keep when overwriting, 0 keeps none
+	Timestamping        bool          // only fetch files newer than the local copy
+	Headers             HeaderList    // --header values, each "Name: value"
+	UserAgent           string        // replaces the default User-Agent header
+	Timeout             time.Duration // default for the connect and read timeouts
+	ConnectTimeout      time.Duration
+	ReadTimeout         time.Duration
+	Method              string // request method for the files, e.g. PUT
+	PostData            string // body of a POST request
+	PostFile            string // file whose content is the body of a POST request
+	BodyData            string // body sent with --method
+	User                string // basic authentication user name
+	Password            string
+	AskPassword         bool   // prompt for the password instead
+	BearerToken         string // token sent as "Authorization: Bearer"
+	LoadCookies         string // cookies.txt file read before the first request
+	SaveCookies         string // cookies.txt file written when the program ends
+	KeepSessionCookies  bool   // also save cookies that expire with the session
+	Proxy               string // proxy URL replacing the proxy environment variables
+	NoProxy             string // hosts reached without the proxy, "*" for all
+	CACertificate       string // PEM bundle of extra trusted authorities
+	CADirectory         string // directory of PEM files of extra trusted authorities
+	Certificate         string // client certificate for mutual TLS
+	PrivateKey          string // key of the client certificate
+	NoCheckCertificate  bool   // do not verify server certificates
+	MinTLSVersion       string // lowest TLS version allowed, e.g. "1.3"
+	PinnedPublicKeys    string // "sha256//<base64>" hashes separated by ";"
+	MaxRedirect         int    // redirects followed before giving up, 0 for the default
+	NoFollowRedirects   bool   // stop at the first redirect instead of following it
+	AllowHTTPSDowngrade bool   // follow redirects from https or ftps to http or ftp
+	Compression         string // encodings to ask for: auto, none, gzip, br or zstd
+	KeepEncoded         bool   // save files as the server encoded them
+	Recursive           bool   // download an FTP directory and everything below it
+	FTPActive           bool   // active instead of passive FTP data connections
+	FTPSImplicit        bool   // TLS from the first byte on ftps:// URLs
+	Jobs                int    // downloads of the -i list at once, 0 for the default
+	MaxPerHost          int    // downloads of the -i list from one host at once, 0 for no limit
+	ForceHTML           bool   // read the -i list as an HTML page and download its links
+	BaseURL             string // URL the relative links of an HTML -i list are resolved against
+	Report              string // file the outcome of every download is written to as JSON lines
 }
 
 func ParseArgs() Inputs {
 	input := &Inputs{}
 	mirrorMode := false // Flag to track if --mirror is set
 	track := false
+	inputNext := false // "-i -" gives the list as the next argument
 
 	// Iterate over the command-line arguments manually
 	for _, arg := range os.Args[1:] {
+		if inputNext {
+			input.Sourcefile = arg // Capture source file
+			inputNext = false
+			continue
+		}
 		// Enforce flags with the '=' sign
 		if strings.HasPrefix(arg, "-O=") {
 			input.File = arg[len("-O="):] // Capture the file name
@@ -41,13 +101,13 @@ func ParseArgs() Inputs {
 		} else if strings.HasPrefix(arg, "--convert-links") {
 			if !mirrorMode {
 				fmt.Println("Error: --convert-links can only be used with --mirror.")
-				os.Exit(1)
+				os.Exit(exitParse)
 			}
 			input.ConvertLinksFlag = true // Enable link conversion
 		} else if strings.HasPrefix(arg, "-R=") || strings.HasPrefix(arg, "--reject=") {
 			if !mirrorMode {
 				fmt.Println("Error: --reject can only be used with --mirror.")
-				os.Exit(1)
+				os.Exit(exitParse)
 			}
 			if strings.HasPrefix(arg, "-R=") {
 				input.RejectFlag = arg[len("-R="):] // Capture reject flag for -R
@@ -57,71 +117,332 @@ func ParseArgs() Inputs {
 		} else if strings.HasPrefix(arg, "-X=") || strings.HasPrefix(arg, "--exclude=") {
 			if !mirrorMode {
 				fmt.Println("Error: --exclude can only be used with --mirror.")
-				os.Exit(1)
+				os.Exit(exitParse)
 			}
 			if strings.HasPrefix(arg, "-X=") {
 				input.ExcludeFlag = arg[len("-X="):] // Capture exclude flag for -X
 			} else {
 				input.ExcludeFlag = arg[len("--exclude="):] // Capture exclude flag for --exclude
 			}
+		} else if arg == "-c" || arg == "--continue" {
+			input.Continue = true // Resume partially downloaded files
+		} else if strings.HasPrefix(arg, "-t=") || strings.HasPrefix(arg, "--tries=") {
+			input.Tries = parsePositiveInt(arg[strings.Index(arg, "=")+1:], "--tries")
+		} else if strings.HasPrefix(arg, "--waitretry=") {
+			input.WaitRetry = parsePositiveInt(arg[len("--waitretry="):], "--waitretry")
+		} else if strings.HasPrefix(arg, "--split=") {
+			input.Split = parsePositiveInt(arg[len("--split="):], "--split")
+		} else if strings.HasPrefix(arg, "--checksum=") {
+			input.Checksum = arg[len("--checksum="):] // Capture the expected digest
+		} else if arg == "--quarantine" {
+			input.Quarantine = true // Keep files that fail verification aside
+		} else if arg == "--remove-partial" {
+			input.RemovePartial = true // Clean up after an interrupted download
+		} else if arg == "-nc" || arg == "--no-clobber" {
+			input.NoClobber = true // Never replace an existing file
+		} else if arg == "-N" || arg == "--timestamping" {
+			input.Timestamping = true // Skip files that did not change on the server
+		} else if arg == "--numbered" {
+			input.Numbered = true // Number new copies of existing files
+		} else if strings.HasPrefix(arg, "--backups=") {
+			input.Backups = parsePositiveInt(arg[len("--backups="):], "--backups")
+		} else if strings.HasPrefix(arg, "--header=") {
+			if err := input.Headers.Set(arg[len("--header="):]); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(exitParse)
+			}
+		} else if strings.HasPrefix(arg, "--user-agent=") {
+			input.UserAgent = arg[len("--user-agent="):] // Capture the User-Agent
+		} else if strings.HasPrefix(arg, "--timeout=") {
+			input.Timeout = parseTimeout(arg[len("--timeout="):], "--timeout")
+		} else if strings.HasPrefix(arg, "--connect-timeout=") {
+			input.ConnectTimeout = parseTimeout(arg[len("--connect-timeout="):], "--connect-timeout")
+		} else if strings.HasPrefix(arg, "--read-timeout=") {
+			input.ReadTimeout = parseTimeout(arg[len("--read-timeout="):], "--read-timeout")
+		} else if strings.HasPrefix(arg, "--method=") {
+			input.Method = strings.ToUpper(arg[len("--method="):]) // Capture the request method
+		} else if strings.HasPrefix(arg, "--post-data=") {
+			input.PostData = arg[len("--post-data="):] // Capture the POST body
+		} else if strings.HasPrefix(arg, "--post-file=") {
+			input.PostFile = arg[len("--post-file="):] // Capture the file holding the POST body
+		} else if strings.HasPrefix(arg, "--body-data=") {
+			input.BodyData = arg[len("--body-data="):] // Capture the body sent with --method
+		} else if strings.HasPrefix(arg, "--user=") || strings.HasPrefix(arg, "--http-user=") {
+			input.User = arg[strings.Index(arg, "=")+1:] // Capture the user name
+		} else if strings.HasPrefix(arg, "--password=") || strings.HasPrefix(arg, "--http-password=") {
+			input.Password = arg[strings.Index(arg, "=")+1:] // Capture the password
+		} else if arg == "--ask-password" {
+			input.AskPassword = true // Prompt for the password
+		} else if strings.HasPrefix(arg, "--auth-bearer=") {
+			input.BearerToken = arg[len("--auth-bearer="):] // Capture the bearer token
+		} else if strings.HasPrefix(arg, "--load-cookies=") {
+			input.LoadCookies = arg[len("--load-cookies="):] // Capture the cookies file to read
+		} else if strings.HasPrefix(arg, "--save-cookies=") {
+			input.SaveCookies = arg[len("--save-cookies="):] // Capture the cookies file to write
+		} else if arg == "--keep-session-cookies" {
+			input.KeepSessionCookies = true // Save session cookies too
+		} else if strings.HasPrefix(arg, "--proxy=") {
+			input.Proxy = arg[len("--proxy="):] // Capture the proxy
+		} else if arg == "--no-proxy" {
+			input.NoProxy = "*" // Never use a proxy
+		} else if strings.HasPrefix(arg, "--no-proxy=") {
+			input.NoProxy = arg[len("--no-proxy="):] // Capture the hosts to reach directly
+		} else if strings.HasPrefix(arg, "--ca-certificate=") {
+			input.CACertificate = arg[len("--ca-certificate="):] // Capture the CA bundle
+		} else if strings.HasPrefix(arg, "--ca-directory=") {
+			input.CADirectory = arg[len("--ca-directory="):] // Capture the CA directory
+		} else if strings.HasPrefix(arg, "--certificate=") {
+			input.Certificate = arg[len("--certificate="):] // Capture the client certificate
+		} else if strings.HasPrefix(arg, "--private-key=") {
+			input.PrivateKey = arg[len("--private-key="):] // Capture the client key
+		} else if arg == "--no-check-certificate" {
+			input.NoCheckCertificate = true // Accept any server certificate
+		} else if strings.HasPrefix(arg, "--min-tls-version=") {
+			input.MinTLSVersion = arg[len("--min-tls-version="):] // Capture the lowest TLS version
+		} else if strings.HasPrefix(arg, "--pinned-pubkey=") {
+			input.PinnedPublicKeys = arg[len("--pinned-pubkey="):] // Capture the public key pins
+		} else if strings.HasPrefix(arg, "--max-redirect=") {
+			// --max-redirect=0 follows no redirects at all
+			if n := parseNonNegativeInt(arg[len("--max-redirect="):], "--max-redirect"); n == 0 {
+				input.NoFollowRedirects = true
+			} else {
+				input.MaxRedirect = n
+			}
+		} else if arg == "--no-follow-redirects" {
+			input.NoFollowRedirects = true // Return redirects instead of following them
+		} else if arg == "--allow-https-downgrade" {
+			input.AllowHTTPSDowngrade = true // Follow redirects from https to http or ftp
+		} else if strings.HasPrefix(arg, "--compression=") {
+			input.Compression = strings.ToLower(arg[len("--compression="):]) // Capture the encodings to ask for
+			switch input.Compression {
+			case "auto", "none", "gzip", "br", "zstd":
+			default:
+				fmt.Printf("Error: --compression expects auto, none, gzip, br or zstd, got '%s'\n", input.Compression)
+				os.Exit(exitParse)
+			}
+		} else if arg == "--keep-encoded" {
+			input.KeepEncoded = true // Save files still compressed
+		} else if arg == "-r" || arg == "--recursive" {
+			input.Recursive = true // Download a whole FTP directory
+		} else if arg == "--no-passive-ftp" {
+			input.FTPActive = true // Let the FTP server connect back
+		} else if arg == "--ftps-implicit" {
+			input.FTPSImplicit = true // Start ftps:// sessions with TLS
+		} else if strings.HasPrefix(arg, "--jobs=") || strings.HasPrefix(arg, "-j=") {
+			input.Jobs = parsePositiveInt(arg[strings.Index(arg, "=")+1:], "--jobs")
+		} else if strings.HasPrefix(arg, "--max-per-host=") {
+			input.MaxPerHost = parsePositiveInt(arg[len("--max-per-host="):], "--max-per-host")
+		} else if arg == "-F" || arg == "--force-html" {
+			input.ForceHTML = true // Read the -i list as an HTML page
+		} else if strings.HasPrefix(arg, "--report=") {
+			input.Report = arg[len("--report="):] // Capture the report file
+			if input.Report == "" {
+				fmt.Println("Error: --report needs a file name.")
+				os.Exit(exitParse)
+			}
+		} else if strings.HasPrefix(arg, "--base=") {
+			input.BaseURL = arg[len("--base="):] // Capture the base of relative links
 		} else if strings.HasPrefix(arg, "-B") {
 			input.WorkInBackground = true // Enable background downloading
 		} else if strings.HasPrefix(arg, "-i=") {
 			input.Sourcefile = arg[len("-i="):] // Capture source file
 			track = true
-		} else if strings.HasPrefix(arg, "http") {
-			// This must be the URL
+		} else if arg == "-i" {
+			inputNext = true // The source file follows, as in "-i -"
+			track = true
+		} else if isSource(arg) {
+			// This must be the URL or a local path
 			input.URL = arg
 		} else {
 			fmt.Printf("Error: Unrecognized argument '%s'\n", arg)
-			os.Exit(1)
+			os.Exit(exitParse)
 		}
 	}
+	if inputNext || (track && input.Sourcefile == "") {
+		fmt.Println("Error: -i needs a file, a URL or - for the standard input.")
+		os.Exit(exitParse)
+	}
 	if input.RateLimit != "" {
 		if strings.ToLower(string(input.RateLimit[len(input.RateLimit)-1])) != "k" &&
 			strings.ToLower(string(input.RateLimit[len(input.RateLimit)-1])) != "m" {
 			fmt.Println("Invalid RateLimit")
-			os.Exit(1)
+			os.Exit(exitParse)
+		}
+	}
+	if countSet(input.NoClobber, input.Numbered, input.Backups > 0) > 1 {
+		fmt.Println("Error: --no-clobber, --numbered and --backups cannot be used together.")
+		os.Exit(exitParse)
+	}
+	if input.Timestamping && (input.NoClobber || input.Numbered) {
+		fmt.Println("Error: -N cannot be used with --no-clobber or --numbered.")
+		os.Exit(exitParse)
+	}
+	if input.PostData != "" && input.PostFile != "" {
+		fmt.Println("Error: --post-data and --post-file cannot be used together.")
+		os.Exit(exitParse)
+	}
+	if input.Method != "" && (input.PostData != "" || input.PostFile != "") {
+		fmt.Println("Error: --method cannot be used with --post-data or --post-file, use --body-data instead.")
+		os.Exit(exitParse)
+	}
+	if input.BodyData != "" && input.Method == "" {
+		fmt.Println("Error: --body-data can only be used with --method.")
+		os.Exit(exitParse)
+	}
+	if input.AskPassword && input.Password != "" {
+		fmt.Println("Error: --password and --ask-password cannot be used together.")
+		os.Exit(exitParse)
+	}
+	if input.BearerToken != "" && (input.User != "" || input.Password != "" || input.AskPassword) {
+		fmt.Println("Error: --auth-bearer cannot be used with --user or --password.")
+		os.Exit(exitParse)
+	}
+	// --timeout sets the connect and read timeouts that are not given on their own
+	if input.Timeout > 0 {
+		if input.ConnectTimeout == 0 {
+			input.ConnectTimeout = input.Timeout
+		}
+		if input.ReadTimeout == 0 {
+			input.ReadTimeout = input.Timeout
+		}
+	}
+	if input.Recursive && !strings.HasPrefix(input.URL, "ftp") {
+		fmt.Println("Error: -r needs an ftp:// or ftps:// URL, use --mirror for websites.")
+		os.Exit(exitParse)
+	}
+	if input.Recursive && (input.File != "" || input.Sourcefile != "") {
+		fmt.Println("Error: -r cannot be used with -O or -i.")
+		os.Exit(exitParse)
+	}
+	if (input.Jobs > 0 || input.MaxPerHost > 0) && input.Sourcefile == "" {
+		fmt.Println("Error: --jobs and --max-per-host can only be used with -i.")
+		os.Exit(exitParse)
+	}
+	if (input.ForceHTML || input.BaseURL != "") && input.Sourcefile == "" {
+		fmt.Println("Error: --force-html and --base can only be used with -i.")
+		os.Exit(exitParse)
+	}
+	if input.BaseURL != "" {
+		if err := validateURL(input.BaseURL); err != nil {
+			fmt.Println("Error: invalid --base URL provided")
+			os.Exit(exitParse)
 		}
 	}
 	if input.WorkInBackground {
 		if input.Sourcefile != "" || input.Path != "" {
 			fmt.Println("-B flag shpuld not be used with -i or -P flags")
-			os.Exit(1)
+			os.Exit(exitParse)
 		}
 	}
 
 	// Check for invalid flag combinations if --mirror is provided
 	if input.Mirroring {
-		// Only allow --convert-links, --reject, and --exclude with --mirror
-		if input.File != "" || input.Path != "" || input.RateLimit != "" || input.Sourcefile != "" || input.WorkInBackground {
-			fmt.Println("Error: --mirror can only be used with --convert-links, --reject, --exclude, and a URL. No other flags are allowed.")
-			os.Exit(1)
+		// Only allow --convert-links, --reject, --exclude and the download behaviour flags with --mirror
+		if input.File != "" || input.Path != "" || input.RateLimit != "" || input.Sourcefile != "" || input.WorkInBackground || input.Split > 0 || input.Checksum != "" ||
+			input.Method != "" || input.PostData != "" || input.PostFile != "" || input.BodyData != "" || input.KeepEncoded {
+			fmt.Println("Error: --mirror can only be used with --convert-links, --reject, --exclude, --continue, --tries, --waitretry, --remove-partial, --no-clobber, --numbered, --backups, -N, --header, --user-agent, the timeouts, the authentication, cookie, proxy, TLS, redirect and --compression flags, --report and a URL. No other flags are allowed.")
+			os.Exit(exitParse)
 		}
 	} else {
 		// If --mirror is not provided, reject the use of --convert-links, --reject, and --exclude
 		if input.ConvertLinksFlag || input.RejectFlag != "" || input.ExcludeFlag != "" {
 			fmt.Println("Error: --convert-links, --reject, and --exclude can only be used with --mirror.")
-			os.Exit(1)
+			os.Exit(exitParse)
 		}
 	}
 
 	// Ensure URL is provided
 	if input.URL == "" && !track {
 		fmt.Println("Error: URL not provided.")
-		os.Exit(1)
+		os.Exit(exitParse)
 
 		// Validate the URL
 		err := validateURL(input.URL)
 		if err != nil {
 			fmt.Println("Error: invalid URL provided")
-			os.Exit(1)
+			os.Exit(exitParse)
 		}
 	}
 
 	return *input
 }
 
+// isSource reports whether arg names something to download: a URL such as
+// http://, ftp://, file:// or data:, or a local path
+func isSource(arg string) bool {
+	if strings.HasPrefix(arg, "http") || strings.HasPrefix(arg, "ftp") ||
+		strings.Contains(arg, "://") || strings.HasPrefix(arg, "data:") {
+		return true
+	}
+	for _, prefix := range []string{"/", "./", "../", "~/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePositiveInt parses the value of a numeric flag, exiting on bad input
+func parsePositiveInt(value, flag string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		fmt.Printf("Error: %s expects a positive number, got '%s'\n", flag, value)
+		os.Exit(exitParse)
+	}
+	return n
+}
+
+// parseNonNegativeInt parses the value of a numeric flag that may be zero,
+// exiting on bad input
+func parseNonNegativeInt(value, flag string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		fmt.Printf("Error: %s expects a number, got '%s'\n", flag, value)
+		os.Exit(exitParse)
+	}
+	return n
+}
+
+// parseTimeout parses a timeout given in seconds, fractions allowed, exiting
+// on bad input
+func parseTimeout(value, flag string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		fmt.Printf("Error: %s expects a positive number of seconds, got '%s'\n", flag, value)
+		os.Exit(exitParse)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// HeaderList collects the values of a repeated --header flag. It is a
+// flag.Value, each Set adding one header.
+type HeaderList []string
+
+func (h *HeaderList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set adds header, which must be of the form "Name: value"
+func (h *HeaderList) Set(header string) error {
+	name, _, found := strings.Cut(header, ":")
+	if !found || strings.TrimSpace(name) == "" || strings.ContainsAny(header, "\r\n") {
+		return fmt.Errorf("--header expects 'Name: value', got '%s'", header)
+	}
+	*h = append(*h, header)
+	return nil
+}
+
+// countSet returns how many of the given options are enabled
+func countSet(options ...bool) int {
+	n := 0
+	for _, set := range options {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
 func validateURL(link string) error {
 	_, err := url.ParseRequestURI(link)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_validateURL(t *testing.T) {
@@ -112,11 +113,186 @@ func TestParseArgs(t *testing.T) {
 			args: []string{"program", "-B", "https://example.com"},
 			want: Inputs{URL: "https://example.com", WorkInBackground: true},
 		},
+		{
+			name: "Continue partial download",
+			args: []string{"program", "-c", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Continue: true},
+		},
+		{
+			name: "Mirror mode with continue",
+			args: []string{"program", "--mirror", "--continue", "https://example.com"},
+			want: Inputs{URL: "https://example.com", Mirroring: true, Continue: true},
+		},
+		{
+			name: "Retry policy",
+			args: []string{"program", "--tries=5", "--waitretry=3", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Tries: 5, WaitRetry: 3},
+		},
+		{
+			name: "Short tries flag",
+			args: []string{"program", "-t=2", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Tries: 2},
+		},
+		{
+			name: "Segmented download",
+			args: []string{"program", "--split=4", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Split: 4},
+		},
+		{
+			name: "Checksum verification",
+			args: []string{"program", "--checksum=sha256:abc", "--quarantine", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Checksum: "sha256:abc", Quarantine: true},
+		},
+		{
+			name: "Remove partial files on interrupt",
+			args: []string{"program", "--remove-partial", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", RemovePartial: true},
+		},
+		{
+			name: "No clobber",
+			args: []string{"program", "-nc", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", NoClobber: true},
+		},
+		{
+			name: "Numbered copies",
+			args: []string{"program", "--numbered", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Numbered: true},
+		},
+		{
+			name: "Backups",
+			args: []string{"program", "--backups=3", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Backups: 3},
+		},
+		{
+			name: "Timestamping",
+			args: []string{"program", "-N", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Timestamping: true},
+		},
+		{
+			name: "Repeated headers and user agent",
+			args: []string{"program", "--header=X-Token: abc", "--header=Accept: text/plain", "--user-agent=wiget/1.0", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Headers: HeaderList{"X-Token: abc", "Accept: text/plain"}, UserAgent: "wiget/1.0"},
+		},
+		{
+			name: "Timeout sets connect and read timeouts",
+			args: []string{"program", "--timeout=10", "--read-timeout=0.5", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Timeout: 10 * time.Second, ConnectTimeout: 10 * time.Second, ReadTimeout: 500 * time.Millisecond},
+		},
+		{
+			name: "Method with body",
+			args: []string{"program", "--method=put", "--body-data={}", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Method: "PUT", BodyData: "{}"},
+		},
+		{
+			name: "Post data",
+			args: []string{"program", "--post-data=a=1", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", PostData: "a=1"},
+		},
+		{
+			name: "Basic authentication",
+			args: []string{"program", "--user=alice", "--password=s3cret", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", User: "alice", Password: "s3cret"},
+		},
+		{
+			name: "Password prompt with wget spelling",
+			args: []string{"program", "--http-user=alice", "--ask-password", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", User: "alice", AskPassword: true},
+		},
+		{
+			name: "Bearer token",
+			args: []string{"program", "--auth-bearer=tok", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", BearerToken: "tok"},
+		},
+		{
+			name: "Cookie files",
+			args: []string{"program", "--load-cookies=in.txt", "--save-cookies=out.txt", "--keep-session-cookies", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", LoadCookies: "in.txt", SaveCookies: "out.txt", KeepSessionCookies: true},
+		},
+		{
+			name: "Proxy with exceptions",
+			args: []string{"program", "--proxy=socks5://proxy:1080", "--no-proxy=.internal,10.0.0.0/8", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Proxy: "socks5://proxy:1080", NoProxy: ".internal,10.0.0.0/8"},
+		},
+		{
+			name: "No proxy at all",
+			args: []string{"program", "--no-proxy", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", NoProxy: "*"},
+		},
+		{
+			name: "TLS settings",
+			args: []string{"program", "--ca-certificate=ca.pem", "--ca-directory=certs", "--certificate=client.pem", "--private-key=client.key", "--min-tls-version=1.3", "--pinned-pubkey=sha256//abc=", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", CACertificate: "ca.pem", CADirectory: "certs", Certificate: "client.pem", PrivateKey: "client.key", MinTLSVersion: "1.3", PinnedPublicKeys: "sha256//abc="},
+		},
+		{
+			name: "No certificate check",
+			args: []string{"program", "--no-check-certificate", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", NoCheckCertificate: true},
+		},
+		{
+			name: "Redirect limit",
+			args: []string{"program", "--max-redirect=5", "--allow-https-downgrade", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", MaxRedirect: 5, AllowHTTPSDowngrade: true},
+		},
+		{
+			name: "No redirects",
+			args: []string{"program", "--max-redirect=0", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", NoFollowRedirects: true},
+		},
+		{
+			name: "Do not follow redirects",
+			args: []string{"program", "--no-follow-redirects", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", NoFollowRedirects: true},
+		},
+		{
+			name: "Compression kept as sent",
+			args: []string{"program", "--compression=ZSTD", "--keep-encoded", "https://example.com/file.iso"},
+			want: Inputs{URL: "https://example.com/file.iso", Compression: "zstd", KeepEncoded: true},
+		},
+		{
+			name: "Recursive FTP",
+			args: []string{"program", "-r", "--no-passive-ftp", "--ftps-implicit", "ftps://files.example.com/pub/"},
+			want: Inputs{URL: "ftps://files.example.com/pub/", Recursive: true, FTPActive: true, FTPSImplicit: true},
+		},
+		{
+			name: "file URL",
+			args: []string{"program", "file:///srv/data.csv"},
+			want: Inputs{URL: "file:///srv/data.csv"},
+		},
+		{
+			name: "Local path",
+			args: []string{"program", "./exports/data.csv"},
+			want: Inputs{URL: "./exports/data.csv"},
+		},
+		{
+			name: "data URL",
+			args: []string{"program", "data:,hello"},
+			want: Inputs{URL: "data:,hello"},
+		},
 		{
 			name: "Input from file",
 			args: []string{"program", "-i=urls.txt"},
 			want: Inputs{Sourcefile: "urls.txt"},
 		},
+		{
+			name: "Worker limits",
+			args: []string{"program", "-i=urls.txt", "--jobs=8", "--max-per-host=2"},
+			want: Inputs{Sourcefile: "urls.txt", Jobs: 8, MaxPerHost: 2},
+		},
+		{
+			name: "Report file",
+			args: []string{"program", "-i=urls.txt", "--report=run.jsonl"},
+			want: Inputs{Sourcefile: "urls.txt", Report: "run.jsonl"},
+		},
+		{
+			name: "List from standard input",
+			args: []string{"program", "-i", "-"},
+			want: Inputs{Sourcefile: "-"},
+		},
+		{
+			name: "Remote HTML list",
+			args: []string{"program", "-i=https://example.com/downloads.html", "--force-html", "--base=https://example.com/files/"},
+			want: Inputs{Sourcefile: "https://example.com/downloads.html", ForceHTML: true, BaseURL: "https://example.com/files/"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -129,7 +305,7 @@ func TestParseArgs(t *testing.T) {
 			_, _ = captureOutput(func() {
 				defer func() {
 					if r := recover(); r != nil {
-						if tt.want == (Inputs{}) {
+						if reflect.DeepEqual(tt.want, Inputs{}) {
 							// This was expected, do nothing
 						} else {
 							t.Errorf("ParseArgs() panicked unexpectedly: %v", r)
@@ -95,8 +95,13 @@ func getLocalPath(originalURL string) string {
 	return originalURL
 }
 
-// removeHTTP removes the http:// or https:// prefix from the URL.
+// removeHTTP removes the http:// or https:// prefix from the URL. A local
+// file:///path is mirrored under localhost/path.
 func removeHTTP(url string) string {
+	if local, found := strings.CutPrefix(url, "file:///"); found {
+		return "localhost/" + local
+	}
+
 	// Regular expression to match the protocol (http or https) at the start of the URL
 	re := regexp.MustCompile(`^https?://`)
 
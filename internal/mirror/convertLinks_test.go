@@ -201,6 +201,13 @@ func Test_removeHTTP(t *testing.T) {
 			},
 			want: "ftp://example.com/resource",
 		},
+		{
+			name: "Local file URL",
+			args: args{
+				url: "file:///srv/site/index.html",
+			},
+			want: "localhost/srv/site/index.html",
+		},
 		{
 			name: "Local URL without protocol",
 			args: args{
@@ -1,10 +1,14 @@
 package mirror
 
 import (
+	"context"
+	"fmt"
 	"net/url"
 	"os"
 	"regexp"
 	"strings"
+
+	"wiget/internal/downloader"
 )
 
 func isRejected(url, rejectTypes string) bool {
@@ -56,13 +60,17 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
-func extractAndHandleStyleURLs(styleContent, baseURL, domain, rejectTypes string) {
+func extractAndHandleStyleURLs(ctx context.Context, styleContent, baseURL, domain, rejectTypes string, opts downloader.Options) {
 	re := regexp.MustCompile(`url\(['"]?([^'"()]+)['"]?\)`)
 	matches := re.FindAllStringSubmatch(styleContent, -1)
 	for _, match := range matches {
 		if len(match) > 1 {
 			assetURL := resolveURL(baseURL, match[1])
-			downloadAsset(assetURL, domain, rejectTypes)
+			if downloader.SchemeFamily(assetURL) != downloader.SchemeFamily(baseURL) {
+				fmt.Printf("Skipping %s, not served like the page that links to it\n", downloader.RedactURL(assetURL))
+				continue
+			}
+			downloadAsset(ctx, assetURL, domain, rejectTypes, opts)
 		}
 	}
 }
@@ -72,6 +80,10 @@ func extractDomain(urlStr string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// Local files belong to this machine
+	if u.Scheme == "file" && u.Hostname() == "" {
+		return "localhost", nil
+	}
 	return u.Hostname(), nil
 }
 
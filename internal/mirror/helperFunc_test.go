@@ -200,6 +200,12 @@ func Test_extractDomain(t *testing.T) {
 			want:    "example.com",
 			wantErr: false,
 		},
+		{
+			name:    "Local file URL",
+			args:    args{urlStr: "file:///srv/site/index.html"},
+			want:    "localhost",
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1,8 +1,11 @@
 package mirror
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"sync"
 
@@ -21,14 +24,22 @@ var (
 	count         int = 0
 )
 
-// DownloadPage downloads a page and its assets, recursively visiting links
-func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string) {
-	domain, err := extractDomain(url)
-	if err != nil {
-		fmt.Println("Could not extract domain name for:", url, "Error:", err)
+// DownloadPage downloads a page and its assets, recursively visiting links.
+// The mirror is scoped to the domain the page ends up at after redirects.
+// url may also be a file:// URL or a local path, whose pages are saved under
+// a "localhost" folder.
+// Cancelling ctx stops the crawl: no new pages or assets are started and the
+// transfers in flight are aborted.
+func DownloadPage(ctx context.Context, url, rejectTypes string, convertLink bool, pathRejects string, opts downloader.Options) {
+	crawl(ctx, downloader.SourceURL(url), "", rejectTypes, convertLink, pathRejects, opts)
+}
+
+// crawl visits the page at url and the links on it that stay on domain. An
+// empty domain is taken from where the first page redirects to.
+func crawl(ctx context.Context, url, domain, rejectTypes string, convertLink bool, pathRejects string, opts downloader.Options) {
+	if ctx.Err() != nil {
 		return
 	}
-	// fmt.Println(url)
 
 	muPages.Lock()
 	if visitedPages[url] {
@@ -38,23 +49,63 @@ func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string)
 	visitedPages[url] = true
 	muPages.Unlock()
 
-	// Check if we're at the root domain and force download of index.html
-	if (strings.TrimRight(url, "/") == "http://"+domain || strings.TrimRight(url, "/") == "https://"+domain) && count == 0 {
-		count++
-		indexURL := strings.TrimRight(url, "/")
-		downloadAsset(indexURL, domain, rejectTypes)
+	// Fetch and get the HTML of the page. With -N a copy saved by an earlier
+	// run is only fetched again when the server has a newer one
+	local := ""
+	if opts.Timestamping {
+		local = localCopy(url)
+	}
+	doc, finalURL, err := fetchAndParsePage(ctx, url, local, opts)
+	if ctx.Err() != nil {
+		return
 	}
-
-	// Fetch and get the HTML of the page
-	doc, err := fetchAndParsePage(url)
 	if err != nil {
 		fmt.Println("Error fetching or parsing page:", err)
+		if domain == "" {
+			// Nothing else records the start page when it cannot be fetched
+			opts.Report.Add(url, downloader.Result{URL: url}, err)
+		}
 		return
 	}
 
+	// Links are relative to where the page was served from, which also
+	// decides the domain of the mirror
+	pageDomain, err := extractDomain(finalURL)
+	if err != nil {
+		fmt.Println("Could not extract domain name for:", finalURL, "Error:", err)
+		return
+	}
+	if domain == "" {
+		domain = pageDomain
+	} else if pageDomain != domain || downloader.SchemeFamily(finalURL) != downloader.SchemeFamily(url) {
+		fmt.Printf("Skipping %s, redirected off the site to %s\n", downloader.RedactURL(url), downloader.RedactURL(finalURL))
+		return
+	}
+	if finalURL != url {
+		muPages.Lock()
+		visitedPages[finalURL] = true
+		muPages.Unlock()
+		url = finalURL
+	}
+
+	// Check if we're at the root domain and force download of index.html.
+	// A local start page is saved as well, as nothing else links to it
+	if (strings.TrimRight(url, "/") == "http://"+domain || strings.TrimRight(url, "/") == "https://"+domain) && count == 0 {
+		count++
+		indexURL := strings.TrimRight(url, "/")
+		downloadAsset(ctx, indexURL, domain, rejectTypes, opts)
+	} else if strings.HasPrefix(url, "file:") && count == 0 {
+		count++
+		downloadAsset(ctx, url, domain, rejectTypes, opts)
+	}
+
 	// Function to handle links and assets found on the page
 	handleLink := func(link, tagName string) {
-		semaphore <- struct{}{}        // Acquire a spot in the semaphore
+		select {
+		case semaphore <- struct{}{}: // Acquire a spot in the semaphore
+		case <-ctx.Done():
+			return
+		}
 		defer func() { <-semaphore }() // Release the spot
 
 		baseURL := resolveURL(url, link)
@@ -69,23 +120,25 @@ func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string)
 			return
 		}
 
-		if baseURLDomain == domain {
+		// A link to local files is never part of a remote site, even one
+		// served from localhost, nor a link to the network part of a local one
+		if baseURLDomain == domain && downloader.SchemeFamily(baseURL) == downloader.SchemeFamily(url) {
 			if tagName == "a" {
 				// Check if the baseURL is the root or equivalent to index.html
 				if strings.HasSuffix(baseURL, "/") || strings.HasSuffix(baseURL, "/index.html") {
 					// Ensure index.html is downloaded first
 					indexURL := strings.TrimRight(baseURL, "/") + "/index.html"
 					if !visitedPages[indexURL] {
-						downloadAsset(indexURL, domain, rejectTypes)
-						DownloadPage(indexURL, rejectTypes, convertLink, pathRejects)
+						downloadAsset(ctx, indexURL, domain, rejectTypes, opts)
+						crawl(ctx, indexURL, domain, rejectTypes, convertLink, pathRejects, opts)
 					}
 				} else {
 					// Process other pages as usual
-					DownloadPage(baseURL, rejectTypes, convertLink, pathRejects)
+					crawl(ctx, baseURL, domain, rejectTypes, convertLink, pathRejects, opts)
 				}
 			}
 			// Download assets, regardless of index.html processing
-			downloadAsset(baseURL, domain, rejectTypes)
+			downloadAsset(ctx, baseURL, domain, rejectTypes, opts)
 		}
 	}
 
@@ -97,7 +150,7 @@ func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string)
 			for _, attr := range n.Attr {
 				if isValidAttribute(n.Data, attr.Key) {
 					link := attr.Val
-					if link != "" {
+					if link != "" && ctx.Err() == nil {
 						wg.Add(1)
 						go func(link, tagName string) {
 							defer wg.Done()
@@ -107,12 +160,12 @@ func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string)
 				}
 				// Check for inline styles
 				if attr.Key == "style" {
-					extractAndHandleStyleURLs(attr.Val, url, domain, rejectTypes)
+					extractAndHandleStyleURLs(ctx, attr.Val, url, domain, rejectTypes, opts)
 				}
 			}
 			// Check for <style> tags
 			if n.Data == "style" && n.FirstChild != nil {
-				extractAndHandleStyleURLs(n.FirstChild.Data, url, domain, rejectTypes)
+				extractAndHandleStyleURLs(ctx, n.FirstChild.Data, url, domain, rejectTypes, opts)
 			}
 		}
 
@@ -128,60 +181,73 @@ func DownloadPage(url, rejectTypes string, convertLink bool, pathRejects string)
 	wg.Wait()
 
 	// Convert links if the flag is set
-	if convertLink {
+	if convertLink && ctx.Err() == nil {
 		convertLinks(url)
 	}
 }
 
-// fetchAndParsePage fetches the content of the URL and parses it as HTML
-func fetchAndParsePage(url string) (*html.Node, error) {
-	resp, err := downloader.HttpRequest(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// fetchAndParsePage fetches the content of the URL and parses it as HTML. It
+// also returns the URL the page was served from after redirects. When local
+// names a saved copy of the page the request is conditional, and the copy is
+// parsed instead if the server says it is current.
+func fetchAndParsePage(ctx context.Context, url, local string, opts downloader.Options) (*html.Node, string, error) {
+	var doc *html.Node
+	finalURL := url
+	err := downloader.Retry(ctx, opts, url, func() error {
+		var resp *http.Response
+		var err error
+		if local != "" {
+			resp, err = downloader.TimestampRequest(ctx, opts, url, local)
+		} else {
+			resp, err = downloader.HttpRequest(ctx, opts, url)
+		}
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		downloader.LogRedirects(os.Stdout, resp)
+		finalURL = downloader.FinalURL(resp, url)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: status %s", resp.Status)
-	}
+		if local != "" && downloader.NotModified(resp, local) {
+			file, err := os.Open(local)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			doc, err = html.Parse(file)
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return downloader.NewStatusError(url, resp)
+		}
 
-	return html.Parse(resp.Body)
+		doc, err = html.Parse(resp.Body)
+		return err
+	})
+	return doc, finalURL, err
 }
 
+// resolveURL resolves the link rel found on the page at base, dropping any
+// fragment identifier (anything starting with #)
 func resolveURL(base, rel string) string {
-	// Remove fragment identifiers (anything starting with #)
-	if fragmentIndex := strings.Index(rel, "#"); fragmentIndex != -1 {
-		rel = rel[:fragmentIndex]
-	}
-
-	if strings.HasPrefix(rel, "http") {
+	baseURL, err := url.Parse(base)
+	if err != nil {
 		return rel
 	}
-
-	if strings.HasPrefix(rel, "//") {
-		protocol := "http:"
-		if strings.HasPrefix(base, "https") {
-			protocol = "https:"
-		}
-		return protocol + rel
-	}
-
-	if strings.HasPrefix(rel, "/") {
-		return strings.Join(strings.Split(base, "/")[:3], "/") + rel
-	}
-	if strings.HasPrefix(rel, "./") {
-		return strings.Join(strings.Split(base, "/")[:3], "/") + rel[1:]
-	}
-	if strings.HasPrefix(rel, "//") && strings.Contains(rel[2:], "/") {
-		baseParts := strings.Split(base, "/")
-		return baseParts[0] + "//" + baseParts[2] + rel[1:]
+	relURL, err := url.Parse(strings.TrimSpace(rel))
+	if err != nil {
+		return rel
 	}
-
-	baseParts := strings.Split(base, "/")
-	return baseParts[0] + "//" + baseParts[2] + "/" + rel
+	resolved := baseURL.ResolveReference(relURL)
+	resolved.Fragment, resolved.RawFragment = "", ""
+	return resolved.String()
 }
 
-func downloadAsset(fileURL, domain, rejectTypes string) {
+func downloadAsset(ctx context.Context, fileURL, domain, rejectTypes string, opts downloader.Options) {
+	if ctx.Err() != nil {
+		return
+	}
 	muAssets.Lock()
 	if visitedAssets[fileURL] {
 		muAssets.Unlock()
@@ -190,7 +256,7 @@ func downloadAsset(fileURL, domain, rejectTypes string) {
 	visitedAssets[fileURL] = true
 	muAssets.Unlock()
 
-	if fileURL == "" || !strings.HasPrefix(fileURL, "http") {
+	if u, err := url.Parse(fileURL); fileURL == "" || err != nil || u.Scheme == "" {
 		fmt.Printf("Invalid URL: %s\n", fileURL)
 		return
 	}
@@ -199,6 +265,8 @@ func downloadAsset(fileURL, domain, rejectTypes string) {
 		fmt.Printf("Skipping rejected file: %s\n", fileURL)
 		return
 	}
-	fmt.Printf("Downloading: %s\n", fileURL)
-	MirrorAsyncDownload("", fileURL, "", domain)
+	fmt.Printf("Downloading: %s\n", downloader.RedactURL(fileURL))
+	// Assets are saved under a folder named after the domain
+	opts.Directory = domain
+	MirrorAsyncDownload(ctx, "", fileURL, opts)
 }
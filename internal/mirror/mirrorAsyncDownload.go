@@ -1,11 +1,9 @@
 package mirror
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -21,7 +19,9 @@ var processedURLs = struct {
 	urls: make(map[string]bool),
 }
 
-func MirrorAsyncDownload(outputFileName, urlStr, limit, directory string) {
+// MirrorAsyncDownload saves the asset at urlStr for a mirror, in the folder
+// opts.Directory names after its site
+func MirrorAsyncDownload(ctx context.Context, outputFileName, urlStr string, opts downloader.Options) {
 	// Check if the URL has already been processed
 	processedURLs.Lock()
 	if processed, exists := processedURLs.urls[urlStr]; exists && processed {
@@ -31,9 +31,6 @@ func MirrorAsyncDownload(outputFileName, urlStr, limit, directory string) {
 	}
 	processedURLs.Unlock()
 
-	// startTime := time.Now()
-	// fmt.Printf("Start at %s\n", startTime.Format("2006-01-02 15:04:05"))
-
 	// Parse the URL to get the path components
 	u, err := url.Parse(urlStr)
 	if err != nil {
@@ -41,97 +38,64 @@ func MirrorAsyncDownload(outputFileName, urlStr, limit, directory string) {
 		return
 	}
 
-	// Create the necessary directories based on the URL path
-	rootPath := downloader.ExpandPath(directory)
-	pathComponents := strings.Split(strings.Trim(u.Path, "/"), "/")
-	relativeDirPath := filepath.Join(pathComponents[:len(pathComponents)-1]...)
-	fullDirPath := filepath.Join(rootPath, relativeDirPath)
-	fileName := pathComponents[len(pathComponents)-1]
+	req := downloader.Request{URL: urlStr, Output: assetOutput(u, urlStr, outputFileName)}
 
-	resp, err := downloader.HttpRequest(urlStr)
+	opts.Log, opts.Progress = nil, nil
+	opts.AdjustExtension, opts.StayOnSite = true, true
+	result, err := downloader.Download(ctx, req, opts)
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error:", err)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Error: status %s url: %s\n", resp.Status, urlStr)
+	if result.Skipped || result.UpToDate {
+		if result.FinalURL != "" && !downloader.SameSite(result.FinalURL, urlStr) {
+			fmt.Printf("Skipping %s, redirected off the site to %s\n", downloader.RedactURL(urlStr), downloader.RedactURL(result.FinalURL))
+		}
 		return
 	}
 
-	contentType := resp.Header.Get("Content-Type")
-	// fmt.Printf("\n\n====>content-typt == %s of url %s\n", contentType, urlStr)
-	// contentLength := resp.ContentLength
-	// fmt.Printf("Content size: %d bytes [~%.2fMB]\n", contentLength, float64(contentLength)/1024/1024)
+	fmt.Printf("\033[32mDownloaded [%s]\033[0m\n", downloader.RedactURL(urlStr))
 
-	if outputFileName == "" {
-		if fileName == "" || strings.HasSuffix(urlStr, "/") {
-			fileName = "index.html"
-		} else if contentType == "text/html" && !strings.HasSuffix(fileName, ".html") {
-			fileName += ".html"
-		}
-		outputFileName = filepath.Join(fullDirPath, fileName)
-	} else {
-		if contentType == "text/html" && !strings.HasSuffix(outputFileName, ".html") {
-			outputFileName += ".html"
-		}
-		outputFileName = filepath.Join(fullDirPath, outputFileName)
-	}
+	// Mark the URL as processed
+	processedURLs.Lock()
+	processedURLs.urls[urlStr] = true
+	processedURLs.Unlock()
+}
 
-	if fullDirPath != "" {
-		if _, err := os.Stat(fullDirPath); os.IsNotExist(err) {
-			err = os.MkdirAll(fullDirPath, 0o755)
-			if err != nil {
-				fmt.Println("Error creating path:", err)
-				return
-			}
+// assetOutput returns where under the folder of its site the asset at u is
+// saved: in the folders of its URL path, named outputFileName if set
+func assetOutput(u *url.URL, urlStr, outputFileName string) string {
+	pathComponents := strings.Split(strings.Trim(u.Path, "/"), "/")
+	name := outputFileName
+	if name == "" {
+		name = pathComponents[len(pathComponents)-1]
+		if name == "" || strings.HasSuffix(urlStr, "/") {
+			name = "index.html"
 		}
 	}
-	if fileExists(outputFileName) {
-		return
-	}
+	return filepath.Join(append(pathComponents[:len(pathComponents)-1], name)...)
+}
 
-	var out *os.File
-	out, err = os.Create(outputFileName)
+// localCopy returns the copy of the page at urlStr saved by an earlier run
+// in the folder of its site, or "" when there is none. HTML pages may have
+// been saved with .html added.
+func localCopy(urlStr string) string {
+	u, err := url.Parse(urlStr)
 	if err != nil {
-		fmt.Printf("Error creating file: %s\n", err)
-		return
+		return ""
 	}
-	defer out.Close()
-
-	var reader io.Reader = resp.Body
-
-	buffer := make([]byte, 32*1024) // 32 KB buffer size
-	var downloaded int64
-	for {
-		n, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			fmt.Println("Error reading response body:", err)
-			return
-		}
-
-		if n > 0 {
-			if _, err := out.Write(buffer[:n]); err != nil {
-				fmt.Println("Error writing to file:", err)
-				return
-			}
-			downloaded += int64(n)
-		}
-
-		if err == io.EOF {
-			break
+	domain, err := extractDomain(urlStr)
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(domain, assetOutput(u, urlStr, ""))
+	for _, candidate := range []string{path, path + ".html"} {
+		if fileExists(candidate) {
+			return candidate
 		}
 	}
-
-	// fmt.Println() // Move to the next line after download completes
-
-	// endTime := time.Now()
-	fmt.Printf("\033[32mDownloaded [%s]\033[0m\n", urlStr)
-	// fmt.Printf("Finished at %s\n", endTime.Format("2006-01-02 15:04:05"))
-
-	// Mark the URL as processed
-	processedURLs.Lock()
-	processedURLs.urls[urlStr] = true
-	processedURLs.Unlock()
+	return ""
 }
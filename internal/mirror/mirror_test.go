@@ -0,0 +1,182 @@
+package mirror
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wiget/internal/downloader"
+
+	"golang.org/x/net/html"
+)
+
+func Test_fetchAndParsePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/home/", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="about.html">About</a></body></html>`))
+	}))
+	defer server.Close()
+
+	doc, finalURL, err := fetchAndParsePage(context.Background(), server.URL+"/", "", downloader.Options{Tries: 1})
+	if err != nil {
+		t.Fatalf("fetchAndParsePage() error = %v", err)
+	}
+	if doc == nil {
+		t.Fatal("fetchAndParsePage() returned no document")
+	}
+	// Links on the page are resolved against where it was served from
+	if want := server.URL + "/home/"; finalURL != want {
+		t.Errorf("fetchAndParsePage() final URL = %q, want %q", finalURL, want)
+	}
+}
+
+func Test_fetchAndParsePageNotModified(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var gotSince string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSince = r.Header.Get("If-Modified-Since")
+		http.ServeContent(w, r, "index.html", modified, strings.NewReader(`<a href="served.html">served</a>`))
+	}))
+	defer server.Close()
+
+	local := filepath.Join(t.TempDir(), "index.html")
+	os.WriteFile(local, []byte(`<a href="saved.html">saved</a>`), 0o644)
+	os.Chtimes(local, modified, modified)
+
+	doc, _, err := fetchAndParsePage(context.Background(), server.URL+"/", local, downloader.Options{Tries: 1})
+	if err != nil {
+		t.Fatalf("fetchAndParsePage() error = %v", err)
+	}
+	if gotSince != modified.Format(http.TimeFormat) {
+		t.Errorf("If-Modified-Since = %q, want the time of the local copy", gotSince)
+	}
+	var page strings.Builder
+	html.Render(&page, doc)
+	if !strings.Contains(page.String(), "saved.html") {
+		t.Errorf("parsed %s, want the local copy the server said is current", page.String())
+	}
+}
+
+func TestDownloadPageLocal(t *testing.T) {
+	site := t.TempDir()
+	files := map[string]string{
+		"index.html":    `<html><head><link href="css/style.css"></head><body><a href="about.html#team">About</a><a href="https://example.com/">Elsewhere</a><img src="data:image/gif;base64,R0lGOD=="></body></html>`,
+		"about.html":    `<html><body><img src="/` + filepath.ToSlash(site)[1:] + `/img/logo.png"></body></html>`,
+		"css/style.css": `body { color: red }`,
+		"img/logo.png":  "png",
+		"unlinked.txt":  "not part of the site",
+	}
+	for name, content := range files {
+		path := filepath.Join(site, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(content), 0o644)
+	}
+
+	// The mirror is saved relative to the working directory
+	wd, _ := os.Getwd()
+	out := t.TempDir()
+	os.Chdir(out)
+	defer os.Chdir(wd)
+
+	report := downloader.NewReport(nil)
+	DownloadPage(context.Background(), filepath.Join(site, "index.html"), "", false, "", downloader.Options{Tries: 1, Report: report})
+
+	saved := filepath.Join(out, "localhost", site)
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(saved, name))
+		if name == "unlinked.txt" {
+			if err == nil {
+				t.Errorf("%s was mirrored without a link to it", name)
+			}
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s holds %q, %v; want the original", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "example.com")); err == nil {
+		t.Errorf("the link to another site was followed")
+	}
+	if summary := report.Summary(); summary.Succeeded != 4 || summary.Failed != 0 || summary.ExitCode != downloader.ExitOK {
+		t.Errorf("report = %+v, want the 4 files of the site downloaded", summary)
+	}
+}
+
+func TestDownloadPageStaysOnScheme(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0o600)
+	secretURL := "file://" + filepath.ToSlash(secret)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="` + secretURL + `">a</a><img src="` + secretURL + `">` +
+			`<div style="background: url(` + secretURL + `)"></div></body></html>`))
+	}))
+	defer server.Close()
+	// Served from localhost, the host name local files are mirrored under
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	wd, _ := os.Getwd()
+	out := t.TempDir()
+	os.Chdir(out)
+	defer os.Chdir(wd)
+
+	DownloadPage(context.Background(), "http://localhost:"+port+"/secret-links.html", "", false, "", downloader.Options{Tries: 1})
+
+	filepath.WalkDir(out, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() == "secret.txt" {
+			t.Errorf("a page served over http mirrored the local file %s", path)
+		}
+		return nil
+	})
+}
+
+func TestMirrorAsyncDownload(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("elsewhere"))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved.png" {
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/logo.png", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	report := downloader.NewReport(nil)
+	opts := downloader.Options{Directory: dir, Tries: 1, Report: report}
+	MirrorAsyncDownload(context.Background(), "", server.URL+"/docs/about", opts)
+	MirrorAsyncDownload(context.Background(), "", server.URL+"/moved.png", opts)
+
+	if _, err := os.Stat(filepath.Join(dir, "docs", "about.html")); err != nil {
+		t.Errorf("the HTML page was not saved as docs/about.html: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "moved.png")); err == nil {
+		t.Errorf("an asset redirected to another site was saved")
+	}
+	if summary := report.Summary(); summary.Succeeded != 1 || summary.Skipped != 1 || summary.Failed != 0 {
+		t.Errorf("report = %+v, want one download and one skipped", summary)
+	}
+}
+
+func TestDownloadPageReportsMissingStart(t *testing.T) {
+	report := downloader.NewReport(nil)
+	DownloadPage(context.Background(), filepath.Join(t.TempDir(), "missing.html"), "", false, "", downloader.Options{Tries: 1, Report: report})
+	if summary := report.Summary(); summary.Failed != 1 || summary.ExitCode != downloader.ExitServer {
+		t.Errorf("report = %+v, want the start page failed with exit code %d", summary, downloader.ExitServer)
+	}
+}
@@ -1,60 +1,126 @@
 package rateLimiter
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type RateLimitedReader struct {
-	reader     io.Reader
+	ctx     context.Context
+	reader  io.Reader
+	limiter *Limiter
+}
+
+// Limiter is a token bucket refilled once a second. Several readers can share
+// one Limiter so that together they stay under a single rate limit.
+type Limiter struct {
+	mu         sync.Mutex
 	rateLimit  int64 // bytes per second
 	bucket     int64
 	lastFilled time.Time
 }
 
-func parseRateLimit(rateLimit string) (int64, error) {
-	if len(rateLimit) < 2 {
-		return 0, fmt.Errorf("invalid rate limit")
-	}
-
-	multiplier := 1
-	switch rateLimit[len(rateLimit)-1] {
-	case 'k', 'K':
-		multiplier = 1024
-		rateLimit = rateLimit[:len(rateLimit)-1]
-	case 'M':
-		multiplier = 1024 * 1024
-		rateLimit = rateLimit[:len(rateLimit)-1]
+// ParseRateLimit turns a rate limit such as "400k" or "2M" into bytes per
+// second. The unit is k or m in either case, bytes without one.
+func ParseRateLimit(rateLimit string) (int64, error) {
+	value := rateLimit
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1024
+			value = value[:len(value)-1]
+		case 'm', 'M':
+			multiplier = 1024 * 1024
+			value = value[:len(value)-1]
+		}
 	}
 
-	rate, err := strconv.Atoi(rateLimit)
-	if err != nil {
-		return 0, err
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate < 1 {
+		return 0, fmt.Errorf("invalid rate limit %q, expected a number followed by k or M", rateLimit)
 	}
-	return int64(rate * multiplier), nil
+	return rate * multiplier, nil
 }
-func NewRateLimitedReader(reader io.Reader, limit string) *RateLimitedReader {
+
+// NewLimiter returns a Limiter for limit. An invalid limit sets no limit
+// rather than stalling every reader; check it with ParseRateLimit first.
+func NewLimiter(limit string) *Limiter {
 	// Convert limit to bytes per second (rateLimit)
-	rateLimit, _ := parseRateLimit(limit)
-	return &RateLimitedReader{reader: reader, rateLimit: rateLimit, lastFilled: time.Now()}
+	rateLimit, _ := ParseRateLimit(limit)
+	return &Limiter{rateLimit: rateLimit, lastFilled: time.Now()}
 }
 
-func (r *RateLimitedReader) Read(p []byte) (n int, err error) {
-	if r.bucket <= 0 {
-		time.Sleep(time.Second)
-		r.bucket = r.rateLimit
-		r.lastFilled = time.Now()
+func NewRateLimitedReader(ctx context.Context, reader io.Reader, limit string) *RateLimitedReader {
+	return NewLimiter(limit).NewReader(ctx, reader)
+}
+
+// NewReader wraps reader so that it draws from the shared bucket of l. Its
+// reads stop waiting for the bucket once ctx is cancelled.
+func (l *Limiter) NewReader(ctx context.Context, reader io.Reader) *RateLimitedReader {
+	return &RateLimitedReader{ctx: ctx, reader: reader, limiter: l}
+}
+
+// take waits until the bucket has tokens and reserves up to want of them, or
+// returns the error of ctx once it is cancelled
+func (l *Limiter) take(ctx context.Context, want int64) (int64, error) {
+	if l.rateLimit <= 0 {
+		return want, nil
 	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		l.mu.Lock()
+		if l.bucket <= 0 && time.Since(l.lastFilled) >= time.Second {
+			l.bucket = l.rateLimit
+			l.lastFilled = time.Now()
+		}
+		if l.bucket > 0 {
+			if want > l.bucket {
+				want = l.bucket
+			}
+			l.bucket -= want
+			l.mu.Unlock()
+			return want, nil
+		}
+		// Wait for the refill without the lock, so that the other readers
+		// can notice their own cancellation meanwhile
+		wait := time.Second - time.Since(l.lastFilled)
+		l.mu.Unlock()
 
-	toRead := int64(len(p))
-	if toRead > r.bucket {
-		toRead = r.bucket
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// giveBack returns reserved tokens that were not used
+func (l *Limiter) giveBack(n int64) {
+	l.mu.Lock()
+	l.bucket += n
+	l.mu.Unlock()
+}
+
+func (r *RateLimitedReader) Read(p []byte) (n int, err error) {
+	toRead, err := r.limiter.take(r.ctx, int64(len(p)))
+	if err != nil {
+		return 0, err
 	}
 
 	n, err = r.reader.Read(p[:toRead])
-	r.bucket -= int64(n)
+	if unused := toRead - int64(n); unused > 0 {
+		r.limiter.giveBack(unused)
+	}
 
 	return n, err
 }
@@ -0,0 +1,46 @@
+package rateLimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterTakeCancelledWhileAnotherWaits(t *testing.T) {
+	limiter := NewLimiter("1")
+	if _, err := limiter.take(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket is empty, so this reader waits for the refill
+	waiting, stop := context.WithCancel(context.Background())
+	defer stop()
+	go limiter.take(waiting, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := limiter.take(cancelled, 1); err == nil {
+		t.Errorf("take() took a token with a cancelled context")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("take() returned after %v, want it not to wait for the other reader", elapsed)
+	}
+}
+
+func TestLimiterTakeWaitsForRefill(t *testing.T) {
+	limiter := NewLimiter("10")
+	start := time.Now()
+	got := int64(0)
+	for got < 10 {
+		n, err := limiter.take(context.Background(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += n
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("took 10 bytes at 10 bytes a second in %v, want the first refill awaited", elapsed)
+	}
+}
@@ -0,0 +1,171 @@
+// Package wiget downloads files over HTTP(S) the way the wiget command does,
+// for programs that want to embed it. A Client prints nothing: every download
+// returns a Result or an error, and messages go to Options.Log if it is set.
+// Only the command itself mirrors sites and prints summaries.
+//
+//	client := wiget.NewClient(wiget.Options{Directory: "/tmp", Continue: true})
+//	res, err := client.Download(ctx, wiget.Request{URL: "https://example.com/file.iso"})
+package wiget
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+
+	"wiget/internal/downloader"
+)
+
+// Options configures a Client. Tries, WaitRetry and the other fields behave
+// like the command line flags of the same name.
+type Options = downloader.Options
+
+// Request names one file to download
+type Request = downloader.Request
+
+// Result describes a finished download
+type Result = downloader.Result
+
+// Progress is reported to Options.Progress while a file downloads
+type Progress = downloader.Progress
+
+// StatusError is returned when the server answers with an unexpected status
+type StatusError = downloader.StatusError
+
+// ChecksumError is returned when a download does not match its digest
+type ChecksumError = downloader.ChecksumError
+
+// HTTPConfig sets the headers, method, body and timeouts of the requests
+type HTTPConfig = downloader.HTTPConfig
+
+// HTTPClient sends requests as an HTTPConfig describes; set it as
+// Options.HTTP
+type HTTPClient = downloader.HTTPClient
+
+// TLSOptions describes CA bundles, client certificates, the lowest TLS
+// version and public key pins; NewTLSConfig turns them into HTTPConfig.TLS
+type TLSOptions = downloader.TLSOptions
+
+// CookieJar keeps cookies between requests and reads and writes Netscape
+// cookies.txt files; set it as HTTPConfig.Cookies
+type CookieJar = downloader.CookieJar
+
+// Fetcher opens the content of a URL as a stream; register one with
+// RegisterFetcher or set it in HTTPConfig.Fetchers to serve a new scheme
+type Fetcher = downloader.Fetcher
+
+// FetcherFunc lets an ordinary function serve as a Fetcher
+type FetcherFunc = downloader.FetcherFunc
+
+// ByteRange is the part of a resource a Fetcher is asked for
+type ByteRange = downloader.ByteRange
+
+// Stream is what a Fetcher returns: a body and what is known about it
+type Stream = downloader.Stream
+
+// Report collects the outcome of the downloads of a run; set it as
+// Options.Report
+type Report = downloader.Report
+
+// Summary holds the totals of a Report
+type Summary = downloader.Summary
+
+// ClobberPolicy says what happens when a download would replace a file
+type ClobberPolicy = downloader.ClobberPolicy
+
+// Policies for Options.Clobber
+const (
+	Overwrite = downloader.Overwrite
+	NoClobber = downloader.NoClobber
+	Numbered  = downloader.Numbered
+)
+
+// Defaults of the command line, applied by NewClient
+const (
+	DefaultTries     = downloader.DefaultTries
+	DefaultWaitRetry = downloader.DefaultWaitRetry
+	ChecksumAuto     = downloader.ChecksumAuto
+)
+
+// Client downloads files with a fixed set of options. It is safe to use from
+// several goroutines at once.
+type Client struct {
+	opts Options
+}
+
+// NewClient returns a Client for opts. Tries and WaitRetry default to the
+// values of the command line when they are zero.
+func NewClient(opts Options) *Client {
+	if opts.Tries == 0 {
+		opts.Tries = DefaultTries
+	}
+	if opts.WaitRetry == 0 {
+		opts.WaitRetry = DefaultWaitRetry
+	}
+	return &Client{opts: opts}
+}
+
+// Options returns the options the client downloads with
+func (c *Client) Options() Options {
+	return c.opts
+}
+
+// Download fetches req into the client's directory. The Result is filled in
+// as far as the download got, even when an error is returned.
+func (c *Client) Download(ctx context.Context, req Request) (Result, error) {
+	return downloader.Download(ctx, req, c.opts)
+}
+
+// DownloadTree fetches the ftp:// or ftps:// directory url and everything
+// below it into a folder named after the host in the client's directory.
+func (c *Client) DownloadTree(ctx context.Context, url string) error {
+	return downloader.DownloadTree(ctx, url, c.opts)
+}
+
+// Open returns the content of url, or the part r of it, as a stream
+func (c *Client) Open(ctx context.Context, url string, r ByteRange) (*Stream, error) {
+	return downloader.Open(ctx, c.opts, url, r)
+}
+
+// RegisterFetcher makes HTTPClients created from now on fetch URLs of scheme
+// with f. The built-in http, https, ftp and ftps schemes cannot be replaced.
+func RegisterFetcher(scheme string, f Fetcher) error {
+	return downloader.RegisterFetcher(scheme, f)
+}
+
+// NewHTTPClient returns an HTTPClient for config. One HTTPClient can be
+// shared by several Clients so that they reuse connections.
+func NewHTTPClient(config HTTPConfig) *HTTPClient {
+	return downloader.NewHTTPClient(config)
+}
+
+// NewTLSConfig builds the TLS configuration described by o
+func NewTLSConfig(o TLSOptions) (*tls.Config, error) {
+	return downloader.NewTLSConfig(o)
+}
+
+// NewCookieJar returns an empty CookieJar
+func NewCookieJar() *CookieJar {
+	return downloader.NewCookieJar()
+}
+
+// ProgressBar returns a Progress callback drawing a progress bar on w, like
+// the one of the command line.
+func ProgressBar(w io.Writer) func(Progress) {
+	return downloader.ProgressBar(w)
+}
+
+// IsRetryable reports whether err is a failure worth trying again
+func IsRetryable(err error) bool {
+	return downloader.IsRetryable(err)
+}
+
+// NewReport starts a Report, writing a JSON line per download to out unless
+// it is nil
+func NewReport(out io.Writer) *Report {
+	return downloader.NewReport(out)
+}
+
+// ExitCode returns the wget exit status that err calls for
+func ExitCode(err error) int {
+	return downloader.ExitCode(err)
+}
@@ -0,0 +1,92 @@
+package wiget
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClientDownload(t *testing.T) {
+	content := []byte("library content")
+	digest := sha256.Sum256(content)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := NewClient(Options{Directory: dir, Tries: 1})
+
+	res, err := client.Download(context.Background(), Request{URL: server.URL + "/file.txt"})
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if want := filepath.Join(dir, "file.txt"); res.Path != want {
+		t.Errorf("Path = %q, want %q", res.Path, want)
+	}
+	if res.Bytes != int64(len(content)) || res.Size != int64(len(content)) {
+		t.Errorf("Bytes, Size = %d, %d, want %d", res.Bytes, res.Size, len(content))
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != `"v1"` {
+		t.Errorf("StatusCode = %d, ETag = %q", res.StatusCode, res.Header.Get("ETag"))
+	}
+	if want := "sha256:" + hex.EncodeToString(digest[:]); res.Checksum != want {
+		t.Errorf("Checksum = %q, want %q", res.Checksum, want)
+	}
+	if got, _ := os.ReadFile(res.Path); string(got) != string(content) {
+		t.Errorf("saved %q, want %q", got, content)
+	}
+
+	_, err = client.Download(context.Background(), Request{URL: server.URL + "/missing.txt"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Download() error = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestClientPrintsNothing(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	// Without a home directory "~" cannot be expanded, which is reported
+	t.Setenv("HOME", "")
+	var log bytes.Buffer
+	client := NewClient(Options{Directory: "~/downloads", Tries: 1, Log: &log})
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = stdout
+	client.Download(context.Background(), Request{URL: server.URL + "/file.txt"})
+	os.Stdout = orig
+
+	if printed, _ := os.ReadFile(stdout.Name()); len(printed) > 0 {
+		t.Errorf("Download() printed %q, want everything in Options.Log", printed)
+	}
+	if !strings.Contains(log.String(), "Error finding home directory") {
+		t.Errorf("Options.Log = %q, want the error expanding the directory", log.String())
+	}
+}
+
+func TestNewClientDefaults(t *testing.T) {
+	opts := NewClient(Options{}).Options()
+	if opts.Tries != DefaultTries || opts.WaitRetry != DefaultWaitRetry {
+		t.Errorf("Tries, WaitRetry = %d, %s, want the command line defaults", opts.Tries, opts.WaitRetry)
+	}
+}